@@ -13,37 +13,1085 @@ package main
 */
 
 import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net"
+	"net/http"
 	"os"
+	"os/exec"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/beevik/ntp"
 )
 
-// Обертка вокруг ntp.Time
-var getNTPTime = func() (time.Time, error) {
-	return ntp.Time("pool.ntp.org")
+// Серверы, опрашиваемые по умолчанию
+const (
+	defaultServers     = "0.pool.ntp.org,1.pool.ntp.org,2.pool.ntp.org,3.pool.ntp.org"
+	defaultHTTPServers = "https://www.google.com,https://www.cloudflare.com,https://www.microsoft.com"
+)
+
+// Таймаут ожидания ответа от каждого источника
+var queryTimeout = 5 * time.Second
+
+// Коды завершения программы. Скрипты могут различать причину сбоя по коду.
+const (
+	exitOK             = 0
+	exitFailure        = 1 // прочие ошибки
+	exitAuthFailed     = 3 // ответ не прошёл проверку подлинности (MAC)
+	exitDNS            = 4 // не удалось разрешить имя сервера
+	exitTimeout        = 5 // сервер не ответил вовремя
+	exitKissOfDeath    = 6 // сервер ответил kiss-of-death (например, RATE или DENY)
+	exitUnsynchronized = 7 // сервер не синхронизирован (стратум 0/16, leap=3, устаревшее время)
+	exitOffset         = 8 // смещение часов превышает порог
+	exitDrift          = 9 // скорость дрейфа часов превышает порог
+)
+
+// errorKind — категория ошибки получения или проверки времени
+type errorKind int
+
+const (
+	kindOther errorKind = iota
+	kindAuth
+	kindDNS
+	kindTimeout
+	kindKissOfDeath
+	kindUnsynchronized
+	kindOffset
+	kindDrift
+)
+
+// Коды завершения для каждой категории ошибок
+var exitCodes = map[errorKind]int{
+	kindOther:          exitFailure,
+	kindAuth:           exitAuthFailed,
+	kindDNS:            exitDNS,
+	kindTimeout:        exitTimeout,
+	kindKissOfDeath:    exitKissOfDeath,
+	kindUnsynchronized: exitUnsynchronized,
+	kindOffset:         exitOffset,
+	kindDrift:          exitDrift,
+}
+
+// timeError — типизированная ошибка с категорией и источником
+type timeError struct {
+	Kind   errorKind
+	Server string
+	Err    error
+}
+
+func (e *timeError) Error() string {
+	if e.Server == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %v", e.Server, e.Err)
+}
+
+func (e *timeError) Unwrap() error {
+	return e.Err
+}
+
+// errAuthFailed — ответ сервера не прошёл проверку подлинности (MAC)
+var errAuthFailed = errors.New("NTP authentication failed")
+
+// Определение категории ошибки источника
+func classify(server string, err error) error {
+	var te *timeError
+	if errors.As(err, &te) {
+		return err
+	}
+
+	kind := kindOther
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &dnsErr) && !dnsErr.IsTimeout:
+		kind = kindDNS
+	case errors.Is(err, os.ErrDeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		kind = kindTimeout
+	case errors.Is(err, errAuthFailed), errors.Is(err, ntp.ErrAuthFailed):
+		kind = kindAuth
+	}
+	return &timeError{Kind: kind, Server: server, Err: err}
+}
+
+// Проверка ответа NTP-сервера на пригодность для синхронизации
+func validateResponse(server string, resp *ntp.Response) error {
+	err := resp.Validate()
+	switch err {
+	case nil:
+		return nil
+	case ntp.ErrAuthFailed:
+		return &timeError{Kind: kindAuth, Server: server, Err: errAuthFailed}
+	case ntp.ErrKissOfDeath:
+		return &timeError{Kind: kindKissOfDeath, Server: server,
+			Err: fmt.Errorf("kiss of death %s", resp.KissCode)}
+	case ntp.ErrInvalidStratum, ntp.ErrInvalidLeapSecond, ntp.ErrServerClockFreshness, ntp.ErrInvalidDispersion:
+		return &timeError{Kind: kindUnsynchronized, Server: server,
+			Err: fmt.Errorf("server is unsynchronized (stratum %d, leap %s): %w", resp.Stratum, leapString(resp.Leap), err)}
+	default:
+		return &timeError{Kind: kindOther, Server: server, Err: err}
+	}
+}
+
+// Категория ошибки; для нескольких серверов — общая категория, если она
+// совпадает у всех, иначе kindOther
+func errorKindOf(err error) errorKind {
+	if te, ok := err.(*timeError); ok {
+		return te.Kind
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs := joined.Unwrap()
+		if len(errs) == 0 {
+			return kindOther
+		}
+		kind := errorKindOf(errs[0])
+		for _, e := range errs[1:] {
+			if errorKindOf(e) != kind {
+				return kindOther
+			}
+		}
+		return kind
+	}
+	if next := errors.Unwrap(err); next != nil {
+		return errorKindOf(next)
+	}
+	return kindOther
+}
+
+// Код завершения, соответствующий ошибке
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	return exitCodes[errorKindOf(err)]
+}
+
+// TimeSource — источник эталонного времени
+type TimeSource interface {
+	// Name возвращает имя источника для отчётов
+	Name() string
+	// Query опрашивает источник и возвращает смещение локальных часов и погрешность
+	Query() (sample, error)
+}
+
+// ntpSource — источник времени на основе NTP-сервера
+type ntpSource struct {
+	Server  string
+	Options ntp.QueryOptions
+}
+
+// Name возвращает адрес NTP-сервера
+func (s ntpSource) Name() string {
+	return s.Server
+}
+
+// Query опрашивает NTP-сервер и проверяет ответ. При включённой аутентификации
+// ответы с неверным или отсутствующим MAC отбрасываются.
+func (s ntpSource) Query() (sample, error) {
+	resp, err := ntp.QueryWithOptions(s.Server, s.Options)
+	if err != nil {
+		return sample{}, err
+	}
+	if err := validateResponse(s.Server, resp); err != nil {
+		return sample{}, err
+	}
+	return sample{
+		Server:   s.Server,
+		Offset:   resp.ClockOffset,
+		Distance: resp.RootDistance,
+		Response: resp,
+	}, nil
+}
+
+// httpDateSource — источник времени на основе заголовка Date HTTP-ответа.
+// Используется там, где UDP-порт 123 закрыт; точность ограничена секундой.
+type httpDateSource struct {
+	URL    string
+	Client *http.Client
+}
+
+// Name возвращает URL источника
+func (s httpDateSource) Name() string {
+	return s.URL
+}
+
+// Query выполняет HEAD-запрос и оценивает смещение по заголовку Date
+func (s httpDateSource) Query() (sample, error) {
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: queryTimeout}
+	}
+
+	start := time.Now()
+	resp, err := client.Head(s.URL)
+	end := time.Now()
+	if err != nil {
+		return sample{}, err
+	}
+	resp.Body.Close()
+
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return sample{}, fmt.Errorf("invalid Date header: %w", err)
+	}
+
+	// Заголовок Date усечён до секунды, поэтому считаем, что истинное время
+	// сервера находится в середине этой секунды, а ответ сформирован в середине запроса
+	rtt := end.Sub(start)
+	midpoint := start.Add(rtt / 2)
+	return sample{
+		Server:   s.URL,
+		Offset:   date.Add(time.Second / 2).Sub(midpoint),
+		Distance: rtt/2 + time.Second/2,
+	}, nil
+}
+
+// fileSource — источник времени, считываемого из файла в формате RFC 3339.
+// Время в файле считается актуальным в момент чтения.
+type fileSource struct {
+	Path  string
+	Error time.Duration // заявленная погрешность времени в файле
+}
+
+// Name возвращает путь к файлу
+func (s fileSource) Name() string {
+	return "file:" + s.Path
+}
+
+// Query читает время из файла
+func (s fileSource) Query() (sample, error) {
+	data, err := os.ReadFile(s.Path)
+	if err != nil {
+		return sample{}, err
+	}
+	now := time.Now()
+	reference, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(data)))
+	if err != nil {
+		return sample{}, err
+	}
+	return sample{Server: s.Name(), Offset: reference.Sub(now), Distance: s.Error}, nil
+}
+
+// manualSource — время, заданное вручную, относительно момента запуска программы
+type manualSource struct {
+	Reference time.Time     // заданное эталонное время
+	LocalAt   time.Time     // локальное время в момент, когда эталон был задан
+	Error     time.Duration // заявленная погрешность
+}
+
+// Name возвращает имя ручного источника
+func (s manualSource) Name() string {
+	return "manual"
+}
+
+// Query возвращает смещение заданного времени относительно локальных часов
+func (s manualSource) Query() (sample, error) {
+	return sample{Server: s.Name(), Offset: s.Reference.Sub(s.LocalAt), Distance: s.Error}, nil
+}
+
+// sample — результат опроса одного сервера
+type sample struct {
+	Server   string
+	Offset   time.Duration // смещение локальных часов относительно сервера
+	Distance time.Duration // погрешность измерения (root distance)
+	Response *ntp.Response // полный ответ сервера для диагностики
+	Err      error
+}
+
+// serverReport — диагностика одного сервера для вывода
+type serverReport struct {
+	Server         string        `json:"server"`
+	Offset         time.Duration `json:"offset_ns"`
+	RTT            time.Duration `json:"rtt_ns"`
+	Stratum        uint8         `json:"stratum"`
+	ReferenceID    string        `json:"reference_id"`
+	RootDelay      time.Duration `json:"root_delay_ns"`
+	RootDispersion time.Duration `json:"root_dispersion_ns"`
+	RootDistance   time.Duration `json:"root_distance_ns"`
+	Leap           string        `json:"leap"`
+	Precision      time.Duration `json:"precision_ns"`
+	Error          string        `json:"error,omitempty"`
+}
+
+// report — итоговый результат работы программы
+type report struct {
+	CurrentTime time.Time      `json:"current_time"`
+	ExactTime   *time.Time     `json:"exact_time,omitempty"`
+	Offset      time.Duration  `json:"offset_ns"`
+	Agreed      []serverReport `json:"agreed"`
+	Rejected    []serverReport `json:"rejected"`
+	Error       string         `json:"error,omitempty"`
+}
+
+// consensus — согласованный результат опроса нескольких серверов
+type consensus struct {
+	Offset   time.Duration // итоговое смещение (медиана смещений согласившихся серверов)
+	Agreed   []sample      // серверы, интервалы которых пересекаются (truechimers)
+	Rejected []sample      // отброшенные серверы (falsetickers и серверы с ошибкой)
+}
+
+// Названия алгоритмов в файле ключей (формат ntp.keys)
+var authTypes = map[string]ntp.AuthType{
+	"MD5":        ntp.AuthMD5,
+	"SHA1":       ntp.AuthSHA1,
+	"SHA256":     ntp.AuthSHA256,
+	"SHA512":     ntp.AuthSHA512,
+	"AES128CMAC": ntp.AuthAES128,
+	"AES256CMAC": ntp.AuthAES256,
+}
+
+// Загрузка симметричных ключей из файла в формате ntp.keys:
+// строки вида "<key ID> <тип> <ключ>", комментарии начинаются с '#'
+func loadKeys(path string) (map[uint16]ntp.AuthOptions, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	keys := make(map[uint16]ntp.AuthOptions)
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: expected \"<key ID> <type> <key>\"", path, lineNo)
+		}
+
+		id, err := strconv.ParseUint(fields[0], 10, 16)
+		if err != nil || id == 0 {
+			return nil, fmt.Errorf("%s:%d: invalid key ID %q", path, lineNo, fields[0])
+		}
+		authType, ok := authTypes[strings.ToUpper(fields[1])]
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: unsupported key type %q", path, lineNo, fields[1])
+		}
+		keys[uint16(id)] = ntp.AuthOptions{Type: authType, Key: fields[2], KeyID: uint16(id)}
+	}
+
+	return keys, scanner.Err()
+}
+
+// Декодирование ключа по тем же правилам, что и в библиотеке ntp:
+// префиксы "HEX:" и "ASCII:", ключи длиннее 20 символов считаются шестнадцатеричными
+func decodeKey(key string) ([]byte, error) {
+	switch {
+	case strings.HasPrefix(key, "HEX:"):
+		return hex.DecodeString(key[4:])
+	case strings.HasPrefix(key, "ASCII:"):
+		return []byte(key[6:]), nil
+	case len(key) > 20:
+		return hex.DecodeString(key)
+	default:
+		return []byte(key), nil
+	}
+}
+
+// Вычисление дайджеста MAC для сообщения. CMAC-алгоритмы сервером не поддерживаются.
+func authDigest(authType ntp.AuthType, key, payload []byte) ([]byte, bool) {
+	if len(key) > 32 {
+		key = key[:32]
+	}
+	data := append(append([]byte(nil), key...), payload...)
+	switch authType {
+	case ntp.AuthMD5:
+		digest := md5.Sum(data)
+		return digest[:], true
+	case ntp.AuthSHA1:
+		digest := sha1.Sum(data)
+		return digest[:], true
+	case ntp.AuthSHA256:
+		digest := sha256.Sum256(data)
+		return digest[:20], true
+	case ntp.AuthSHA512:
+		digest := sha512.Sum512(data)
+		return digest[:20], true
+	}
+	return nil, false
+}
+
+// Опрос одного источника; ошибка сохраняется в результате
+func querySample(source TimeSource) sample {
+	s, err := source.Query()
+	if err != nil {
+		return sample{Server: source.Name(), Err: classify(source.Name(), err)}
+	}
+	return s
+}
+
+// Текстовое представление индикатора коррекции секунды
+func leapString(leap ntp.LeapIndicator) string {
+	switch leap {
+	case ntp.LeapNoWarning:
+		return "none"
+	case ntp.LeapAddSecond:
+		return "add second"
+	case ntp.LeapDelSecond:
+		return "delete second"
+	default:
+		return "not in sync"
+	}
+}
+
+// Диагностика сервера на основе его ответа
+func (s sample) report() serverReport {
+	r := serverReport{Server: s.Server, Offset: s.Offset}
+	if s.Err != nil {
+		r.Error = s.Err.Error()
+	}
+	if resp := s.Response; resp != nil {
+		r.RTT = resp.RTT
+		r.Stratum = resp.Stratum
+		r.ReferenceID = resp.ReferenceString()
+		r.RootDelay = resp.RootDelay
+		r.RootDispersion = resp.RootDispersion
+		r.RootDistance = resp.RootDistance
+		r.Leap = leapString(resp.Leap)
+		r.Precision = resp.Precision
+	}
+	return r
+}
+
+// Параллельный опрос списка источников
+func querySamples(sources []TimeSource) []sample {
+	samples := make([]sample, len(sources))
+
+	var wg sync.WaitGroup
+	for i, source := range sources {
+		wg.Add(1)
+		go func(i int, source TimeSource) {
+			defer wg.Done()
+			samples[i] = querySample(source)
+		}(i, source)
+	}
+	wg.Wait()
+
+	return samples
+}
+
+// Отбор согласованных серверов по алгоритму Марзулло.
+// Каждому серверу соответствует интервал [offset-distance, offset+distance],
+// ищется точка, покрытая наибольшим числом интервалов. Серверы, чьи интервалы
+// её не содержат, считаются falsetickers и отбрасываются.
+func selectTruechimers(samples []sample) (consensus, error) {
+	var result consensus
+	var valid []sample
+	for _, s := range samples {
+		if s.Err != nil {
+			result.Rejected = append(result.Rejected, s)
+			continue
+		}
+		valid = append(valid, s)
+	}
+	if len(valid) == 0 {
+		// Сохраняем причины отказа, чтобы по ним можно было выбрать код завершения
+		var errs []error
+		for _, s := range result.Rejected {
+			errs = append(errs, s.Err)
+		}
+		return result, fmt.Errorf("no server responded: %w", errors.Join(errs...))
+	}
+
+	// Границы интервалов: начало интервала (+1) сортируется раньше конца (-1)
+	// при равных значениях, чтобы касающиеся интервалы считались пересекающимися
+	type edge struct {
+		value time.Duration
+		kind  int
+	}
+	edges := make([]edge, 0, 2*len(valid))
+	for _, s := range valid {
+		edges = append(edges, edge{s.Offset - s.Distance, +1}, edge{s.Offset + s.Distance, -1})
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].value != edges[j].value {
+			return edges[i].value < edges[j].value
+		}
+		return edges[i].kind > edges[j].kind
+	})
+
+	best, count := 0, 0
+	var point time.Duration
+	for _, e := range edges {
+		count += e.kind
+		if count > best {
+			best = count
+			point = e.value
+		}
+	}
+
+	// Согласие требует строгого большинства ответивших серверов
+	if best*2 <= len(valid) {
+		result.Rejected = append(result.Rejected, valid...)
+		return result, fmt.Errorf("no majority agreement among %d servers", len(valid))
+	}
+
+	var offsets []time.Duration
+	for _, s := range valid {
+		if s.Offset-s.Distance <= point && point <= s.Offset+s.Distance {
+			result.Agreed = append(result.Agreed, s)
+			offsets = append(offsets, s.Offset)
+		} else {
+			result.Rejected = append(result.Rejected, s)
+		}
+	}
+	result.Offset = median(offsets)
+
+	return result, nil
+}
+
+// Медиана набора смещений
+func median(values []time.Duration) time.Duration {
+	sorted := append([]time.Duration(nil), values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// Разбор списка серверов, разделённых запятыми
+func parseServers(list string) []string {
+	var servers []string
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			servers = append(servers, s)
+		}
+	}
+	return servers
+}
+
+// Создание источников времени выбранного типа
+func buildSources(kind string, servers []string, file, manual string, auth ntp.AuthOptions) ([]TimeSource, error) {
+	var sources []TimeSource
+	switch kind {
+	case "ntp":
+		for _, server := range servers {
+			options := ntp.QueryOptions{Timeout: queryTimeout, Auth: auth}
+			sources = append(sources, ntpSource{Server: server, Options: options})
+		}
+	case "http":
+		client := &http.Client{Timeout: queryTimeout}
+		for _, server := range servers {
+			if !strings.Contains(server, "://") {
+				server = "https://" + server
+			}
+			sources = append(sources, httpDateSource{URL: server, Client: client})
+		}
+	case "file":
+		if file == "" {
+			return nil, errors.New("file source requires -file")
+		}
+		sources = append(sources, fileSource{Path: file, Error: time.Second})
+	case "manual":
+		reference, err := time.Parse(time.RFC3339Nano, manual)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -manual-time: %w", err)
+		}
+		sources = append(sources, manualSource{Reference: reference, LocalAt: time.Now(), Error: time.Second})
+	default:
+		return nil, fmt.Errorf("unknown time source %q", kind)
+	}
+
+	if len(sources) == 0 {
+		return nil, errors.New("no time sources specified")
+	}
+	return sources, nil
 }
 
 // Функция для получения точного времени с обработкой ошибок
-func getExactTime() (time.Time, error) {
-	return getNTPTime()
+func getExactTime(sources []TimeSource) (time.Time, consensus, error) {
+	result, err := selectTruechimers(querySamples(sources))
+	if err != nil {
+		return time.Time{}, result, err
+	}
+	return time.Now().Add(result.Offset), result, nil
+}
+
+// Сборка итогового отчёта
+func buildReport(current, exact time.Time, result consensus, err error) report {
+	r := report{
+		CurrentTime: current,
+		Offset:      result.Offset,
+		Agreed:      []serverReport{},
+		Rejected:    []serverReport{},
+	}
+	if !exact.IsZero() {
+		r.ExactTime = &exact
+	}
+	for _, s := range result.Agreed {
+		r.Agreed = append(r.Agreed, s.report())
+	}
+	for _, s := range result.Rejected {
+		r.Rejected = append(r.Rejected, s.report())
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
+
+// Вывод диагностики сервера в текстовом виде
+func printServer(s serverReport) {
+	fmt.Printf("Server %s:\n", s.Server)
+	fmt.Printf("  offset:          %v\n", s.Offset)
+	if s.ReferenceID == "" {
+		// Подробная диагностика доступна только для NTP-источников
+		return
+	}
+	fmt.Printf("  round-trip:      %v\n", s.RTT)
+	fmt.Printf("  stratum:         %d\n", s.Stratum)
+	fmt.Printf("  reference ID:    %s\n", s.ReferenceID)
+	fmt.Printf("  root delay:      %v\n", s.RootDelay)
+	fmt.Printf("  root dispersion: %v\n", s.RootDispersion)
+	fmt.Printf("  root distance:   %v\n", s.RootDistance)
+	fmt.Printf("  leap indicator:  %s\n", s.Leap)
+	fmt.Printf("  precision:       %v\n", s.Precision)
+}
+
+// Проверка смещения часов на превышение порога (0 — не проверять)
+func checkOffset(offset, limit time.Duration) error {
+	abs := offset
+	if abs < 0 {
+		abs = -abs
+	}
+	if limit > 0 && abs > limit {
+		return &timeError{Kind: kindOffset, Err: fmt.Errorf("clock offset %v exceeds threshold %v", offset, limit)}
+	}
+	return nil
+}
+
+// driftPoint — одно измерение смещения в режиме мониторинга
+type driftPoint struct {
+	At     time.Time
+	Offset time.Duration
+}
+
+// driftMonitor отслеживает смещение часов во времени и оценивает скорость дрейфа
+type driftMonitor struct {
+	MaxOffset time.Duration // порог абсолютного смещения (0 — не проверять)
+	MaxDrift  float64       // порог скорости дрейфа в ppm (0 — не проверять)
+	Window    int           // число последних измерений для оценки тренда
+	points    []driftPoint
+}
+
+// Добавление измерения; возвращает ошибку, если превышен один из порогов
+func (m *driftMonitor) add(p driftPoint) error {
+	m.points = append(m.points, p)
+	if m.Window > 0 && len(m.points) > m.Window {
+		m.points = m.points[len(m.points)-m.Window:]
+	}
+
+	if err := checkOffset(p.Offset, m.MaxOffset); err != nil {
+		return err
+	}
+
+	if drift, ok := m.drift(); ok && m.MaxDrift > 0 && (drift > m.MaxDrift || drift < -m.MaxDrift) {
+		return &timeError{Kind: kindDrift, Err: fmt.Errorf("clock drift %.2f ppm exceeds threshold %.2f ppm", drift, m.MaxDrift)}
+	}
+	return nil
+}
+
+// Скорость дрейфа в ppm — наклон прямой, построенной методом наименьших квадратов
+// по измерениям в окне. Возвращает false, если измерений недостаточно.
+func (m *driftMonitor) drift() (float64, bool) {
+	n := float64(len(m.points))
+	if n < 2 {
+		return 0, false
+	}
+
+	start := m.points[0].At
+	var sumX, sumY, sumXY, sumXX float64
+	for _, p := range m.points {
+		x := p.At.Sub(start).Seconds()
+		y := p.Offset.Seconds()
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return 0, false
+	}
+	return (n*sumXY - sumX*sumY) / denominator * 1e6, true
+}
+
+// Запуск команды-обработчика тревоги; параметры передаются через окружение
+func runHook(ctx context.Context, hook string, p driftPoint, drift float64, alert error) error {
+	cmd := exec.CommandContext(ctx, "sh", "-c", hook)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"CLOCK_OFFSET="+p.Offset.String(),
+		fmt.Sprintf("CLOCK_DRIFT_PPM=%.3f", drift),
+		"CLOCK_ALERT="+alert.Error(),
+	)
+	return cmd.Run()
+}
+
+// Режим мониторинга: периодический опрос серверов и отслеживание дрейфа.
+// Без команды-обработчика первая тревога завершает мониторинг с ошибкой,
+// с обработчиком — обработчик запускается, а мониторинг продолжается.
+// count ограничивает число опросов (0 — без ограничения).
+func runMonitor(ctx context.Context, out io.Writer, sources []TimeSource, m *driftMonitor,
+	interval time.Duration, count int, hook string, jsonOutput bool) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// Ошибка последнего опроса, пока ни один опрос не удался
+	var failure error
+	succeeded := false
+
+	for i := 0; count == 0 || i < count; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return failure
+			case <-ticker.C:
+			}
+		}
+
+		_, result, err := getExactTime(sources)
+		if err != nil {
+			// Неудачный опрос не считается тревогой, пробуем на следующем шаге
+			log.Printf("Error fetching exact time: %v\n", err)
+			if !succeeded {
+				failure = err
+			}
+			continue
+		}
+		succeeded, failure = true, nil
+
+		p := driftPoint{At: time.Now(), Offset: result.Offset}
+		alert := m.add(p)
+		drift, _ := m.drift()
+
+		if jsonOutput {
+			line := struct {
+				At       time.Time     `json:"time"`
+				Offset   time.Duration `json:"offset_ns"`
+				DriftPPM float64       `json:"drift_ppm"`
+				Alert    string        `json:"alert,omitempty"`
+			}{At: p.At, Offset: p.Offset, DriftPPM: drift}
+			if alert != nil {
+				line.Alert = alert.Error()
+			}
+			json.NewEncoder(out).Encode(line)
+		} else {
+			fmt.Fprintf(out, "%s offset=%v drift=%.3fppm\n", p.At.Format(time.RFC3339), p.Offset, drift)
+		}
+
+		if alert == nil {
+			continue
+		}
+		log.Printf("ALERT: %v\n", alert)
+		if hook == "" {
+			return alert
+		}
+		if err := runHook(ctx, hook, p, drift, alert); err != nil {
+			log.Printf("Alert hook failed: %v\n", err)
+		}
+	}
+	return failure
+}
+
+// Смещение эпохи NTP (1900) относительно эпохи Unix (1970) в секундах
+const ntpEpochOffset = 2208988800
+
+// Размер заголовка NTP-пакета
+const ntpPacketSize = 48
+
+// Перевод времени в 64-битную метку времени NTP
+func toNTPTimestamp(t time.Time) uint64 {
+	nsec := uint64(t.Sub(time.Unix(-ntpEpochOffset, 0)))
+	sec := nsec / uint64(time.Second)
+	frac := (nsec % uint64(time.Second)) << 32 / uint64(time.Second)
+	return sec<<32 | frac
+}
+
+// sntpServer — простой SNTP-сервер (RFC 4330) для тестирования клиента без доступа к сети.
+// Отвечает локальным временем со сдвигом Offset, позволяет задать стратум,
+// индикатор коррекции секунды и kiss-of-death ответы.
+type sntpServer struct {
+	Offset   time.Duration              // искусственное смещение отдаваемого времени
+	Stratum  uint8                      // стратум сервера (по умолчанию 1)
+	Leap     ntp.LeapIndicator          // индикатор коррекции секунды
+	KissCode string                     // код kiss-of-death; если задан, отвечаем пакетом со стратумом 0
+	Keys     map[uint16]ntp.AuthOptions // ключи для подписи ответов на аутентифицированные запросы
+	now      func() time.Time
+}
+
+// Проверка MAC запроса и подпись ответа тем же ключом. Запросы без MAC
+// получают неподписанный ответ; при неизвестном ключе или неверном MAC
+// ответ тоже не подписывается, и клиент отклонит его.
+func (s *sntpServer) sign(req, resp []byte) []byte {
+	if len(req) <= ntpPacketSize+4 {
+		return resp
+	}
+	mac := req[ntpPacketSize:]
+	opt, ok := s.Keys[uint16(binary.BigEndian.Uint32(mac[:4]))]
+	if !ok {
+		return resp
+	}
+	key, err := decodeKey(opt.Key)
+	if err != nil {
+		return resp
+	}
+	digest, ok := authDigest(opt.Type, key, req[:ntpPacketSize])
+	if !ok || subtle.ConstantTimeCompare(digest, mac[4:]) != 1 {
+		return resp
+	}
+
+	digest, _ = authDigest(opt.Type, key, resp)
+	return append(append(resp, mac[:4]...), digest...)
+}
+
+// Формирование ответа на запрос клиента. Возвращает false, если запрос
+// не является корректным клиентским SNTP-запросом и должен быть проигнорирован.
+func (s *sntpServer) respond(req []byte, received time.Time) ([]byte, bool) {
+	if len(req) < ntpPacketSize {
+		return nil, false
+	}
+	version := (req[0] >> 3) & 0x07
+	mode := req[0] & 0x07
+	if mode != 3 || version < 1 || version > 4 { // 3 — режим клиента
+		return nil, false
+	}
+
+	resp := make([]byte, ntpPacketSize)
+	stratum := s.Stratum
+	if stratum == 0 {
+		stratum = 1
+	}
+	refID := []byte("LOCL")
+	if s.KissCode != "" {
+		stratum = 0
+		refID = []byte((s.KissCode + "    ")[:4])
+	}
+
+	resp[0] = uint8(s.Leap)<<6 | version<<3 | 4 // 4 — режим сервера
+	resp[1] = stratum
+	resp[2] = req[2]      // интервал опроса берём из запроса
+	resp[3] = uint8(0xec) // точность 2^-20 с (около 1 мкс)
+	copy(resp[12:16], refID)
+
+	receiveTime := received.Add(s.Offset)
+	binary.BigEndian.PutUint64(resp[16:24], toNTPTimestamp(receiveTime)) // reference time
+	copy(resp[24:32], req[40:48])                                        // originate = transmit клиента
+	binary.BigEndian.PutUint64(resp[32:40], toNTPTimestamp(receiveTime))
+	binary.BigEndian.PutUint64(resp[40:48], toNTPTimestamp(s.now().Add(s.Offset)))
+
+	return s.sign(req, resp), true
+}
+
+// Обработка запросов на соединении до его закрытия
+func (s *sntpServer) serve(conn net.PacketConn) error {
+	if s.now == nil {
+		s.now = time.Now
+	}
+
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		received := s.now()
+
+		resp, ok := s.respond(buf[:n], received)
+		if !ok {
+			continue
+		}
+		if _, err := conn.WriteTo(resp, addr); err != nil {
+			log.Printf("Error sending SNTP response to %v: %v\n", addr, err)
+		}
+	}
 }
 
 func main() {
+	sourceKind := flag.String("source", "ntp", "Time source: ntp, http, file or manual")
+	serverList := flag.String("servers", "", "Comma-separated list of NTP servers or HTTP URLs")
+	timeFile := flag.String("file", "", "File with an RFC 3339 timestamp for the file source")
+	manualTime := flag.String("manual-time", "", "RFC 3339 timestamp for the manual source")
+	jsonOutput := flag.Bool("json", false, "Print the report as JSON")
+	flag.DurationVar(&queryTimeout, "timeout", queryTimeout, "Timeout for each NTP query")
+	monitor := flag.Bool("monitor", false, "Poll time sources continuously and track clock drift")
+	interval := flag.Duration("interval", time.Minute, "Polling interval in monitor mode")
+	count := flag.Int("count", 0, "Number of polls in monitor mode (0 means unlimited)")
+	maxOffset := flag.Duration("max-offset", 0, "Fail when the clock offset exceeds this value (0 disables)")
+	maxDrift := flag.Float64("max-drift", 0, "Alert when the drift rate exceeds this value in ppm (0 disables)")
+	window := flag.Int("window", 10, "Number of recent polls used to estimate the drift rate")
+	hook := flag.String("hook", "", "Shell command to run on alert instead of exiting")
+	serveAddr := flag.String("serve", "", "Run an SNTP server on this UDP address instead of querying")
+	serveOffset := flag.Duration("serve-offset", 0, "Fake offset added to the time served in server mode")
+	serveStratum := flag.Uint("serve-stratum", 1, "Stratum reported in server mode")
+	serveLeap := flag.Uint("serve-leap", 0, "Leap indicator reported in server mode (0-3)")
+	serveKoD := flag.String("serve-kod", "", "Answer every request with this kiss-of-death code (e.g. RATE)")
+	keysFile := flag.String("keys", "", "File with symmetric keys in ntp.keys format")
+	keyID := flag.Uint("key-id", 0, "Key ID from the keys file used to authenticate NTP queries")
+	flag.Parse()
+
+	// Загрузка ключей для аутентификации запросов и подписи ответов сервера
+	var keys map[uint16]ntp.AuthOptions
+	var auth ntp.AuthOptions
+	if *keysFile != "" {
+		var err error
+		if keys, err = loadKeys(*keysFile); err != nil {
+			log.Printf("Error loading keys: %v\n", err)
+			os.Exit(exitFailure)
+		}
+	}
+	if *keyID != 0 {
+		opt, ok := keys[uint16(*keyID)]
+		if *keyID > 0xffff || !ok {
+			log.Printf("Key ID %d not found in keys file\n", *keyID)
+			os.Exit(exitFailure)
+		}
+		auth = opt
+	}
+
+	if *serveAddr != "" {
+		if *serveLeap > 3 || *serveStratum > 16 {
+			log.Println("Invalid leap indicator or stratum for server mode")
+			os.Exit(exitFailure)
+		}
+		conn, err := net.ListenPacket("udp", *serveAddr)
+		if err != nil {
+			log.Printf("Error starting SNTP server: %v\n", err)
+			os.Exit(exitFailure)
+		}
+
+		// Закрываем соединение по сигналу завершения
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		go func() {
+			<-ctx.Done()
+			conn.Close()
+		}()
+
+		log.Printf("SNTP server listening on %v\n", conn.LocalAddr())
+		srv := &sntpServer{
+			Offset:   *serveOffset,
+			Stratum:  uint8(*serveStratum),
+			Leap:     ntp.LeapIndicator(*serveLeap),
+			KissCode: *serveKoD,
+			Keys:     keys,
+		}
+		if err := srv.serve(conn); err != nil {
+			log.Printf("SNTP server error: %v\n", err)
+			stop()
+			os.Exit(exitFailure)
+		}
+		return
+	}
+
+	// Список серверов по умолчанию зависит от типа источника
+	if *serverList == "" {
+		*serverList = defaultServers
+		if *sourceKind == "http" {
+			*serverList = defaultHTTPServers
+		}
+	}
+	sources, err := buildSources(*sourceKind, parseServers(*serverList), *timeFile, *manualTime, auth)
+	if err != nil {
+		log.Printf("Error configuring time sources: %v\n", err)
+		os.Exit(exitFailure)
+	}
+
+	if *monitor {
+		if *interval <= 0 {
+			log.Println("Polling interval must be positive")
+			os.Exit(exitFailure)
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		m := &driftMonitor{MaxOffset: *maxOffset, MaxDrift: *maxDrift, Window: *window}
+		if err := runMonitor(ctx, os.Stdout, sources, m, *interval, *count, *hook, *jsonOutput); err != nil {
+			stop()
+			os.Exit(exitCode(err))
+		}
+		return
+	}
+
 	// Получаем текущее время
 	currentTime := time.Now()
-	fmt.Println("Current time:", currentTime.Format(time.RFC1123))
 
 	// Получаем точное время с использованием функции
-	exactTime, err := getExactTime()
-	if err != nil {
-		// Выводим ошибку в STDERR и завершаем программу с ненулевым кодом
-		log.Printf("Error fetching NTP time: %v\n", err)
-		os.Exit(1)
+	exactTime, result, err := getExactTime(sources)
+	if err == nil {
+		err = checkOffset(result.Offset, *maxOffset)
+	}
+	r := buildReport(currentTime, exactTime, result, err)
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if encErr := encoder.Encode(r); encErr != nil {
+			log.Printf("Error encoding report: %v\n", encErr)
+			os.Exit(exitFailure)
+		}
+		if err != nil {
+			os.Exit(exitCode(err))
+		}
+		return
 	}
 
-	// Выводим точное время
+	fmt.Println("Current time:", currentTime.Format(time.RFC1123))
+	for _, s := range result.Rejected {
+		if s.Err != nil {
+			log.Printf("Server %s failed: %v\n", s.Server, s.Err)
+		} else {
+			log.Printf("Server %s rejected as falseticker (offset %v)\n", s.Server, s.Offset)
+		}
+	}
+	if exactTime.IsZero() {
+		// Выводим ошибку в STDERR и завершаем программу с кодом, соответствующим причине
+		log.Printf("Error fetching exact time: %v\n", err)
+		os.Exit(exitCode(err))
+	}
+
+	// Выводим точное время, согласованное смещение и диагностику серверов
 	fmt.Println("Exact time:", exactTime.Format(time.RFC1123))
+	fmt.Println("Offset:", result.Offset)
+	for _, s := range r.Agreed {
+		printServer(s)
+	}
+
+	// Время получено, но смещение превышает порог
+	if err != nil {
+		log.Printf("Error: %v\n", err)
+		os.Exit(exitCode(err))
+	}
 }
@@ -1,24 +1,65 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/beevik/ntp"
 )
 
+// fakeSource — источник времени с заранее заданным результатом для тестов
+type fakeSource struct {
+	name   string
+	offset time.Duration
+	err    error
+}
+
+func (s fakeSource) Name() string {
+	return s.name
+}
+
+func (s fakeSource) Query() (sample, error) {
+	if s.err != nil {
+		return sample{}, s.err
+	}
+	return sample{Server: s.name, Offset: s.offset, Distance: time.Millisecond}, nil
+}
+
+// Запуск локального SNTP-сервера для теста
+func startSNTPServer(t *testing.T, srv *sntpServer) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start SNTP server: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go srv.serve(conn)
+	return conn.LocalAddr().String()
+}
+
 // Тест для успешного получения времени
 func TestGetExactTime_Success(t *testing.T) {
-	// Мокаем функцию main.getNTPTime для теста
-	getNTPTime = func() (time.Time, error) {
-		// Используем текущее время системы для теста
-		return time.Now(), nil
-	}
+	// Источники без смещения относительно времени системы
+	sources := []TimeSource{fakeSource{name: "a"}, fakeSource{name: "b"}, fakeSource{name: "c"}}
 
 	// Вызов функции
-	exactTime, err := getExactTime()
+	exactTime, result, err := getExactTime(sources)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
+	if len(result.Agreed) != 3 {
+		t.Errorf("Expected 3 agreed servers, got %d", len(result.Agreed))
+	}
 
 	// Получаем текущее время для проверки
 	expected := time.Now().Format(time.RFC1123)
@@ -30,18 +71,310 @@ func TestGetExactTime_Success(t *testing.T) {
 // Тест для случая ошибки
 func TestGetExactTime_Error(t *testing.T) {
 	// Мокаем ошибку
-	getNTPTime = func() (time.Time, error) {
-		return time.Time{}, errors.New("NTP server error")
-	}
+	serverErr := errors.New("NTP server error")
+	sources := []TimeSource{fakeSource{name: "a", err: serverErr}, fakeSource{name: "b", err: serverErr}}
 
 	// Вызов функции
-	_, err := getExactTime()
+	_, result, err := getExactTime(sources)
 	if err == nil {
 		t.Fatalf("Expected error, got none")
 	}
+	if len(result.Rejected) != 2 || !errors.Is(result.Rejected[0].Err, serverErr) {
+		t.Errorf("Expected both servers rejected with NTP server error, got %v", result.Rejected)
+	}
+}
+
+// Тест для отбраковки сервера с неверным временем
+func TestSelectTruechimers(t *testing.T) {
+	samples := []sample{
+		{Server: "a", Offset: 10 * time.Millisecond, Distance: 5 * time.Millisecond},
+		{Server: "b", Offset: 12 * time.Millisecond, Distance: 5 * time.Millisecond},
+		{Server: "c", Offset: 8 * time.Millisecond, Distance: 5 * time.Millisecond},
+		{Server: "bad", Offset: 3 * time.Second, Distance: 5 * time.Millisecond},
+		{Server: "down", Err: errors.New("timeout")},
+	}
+
+	result, err := selectTruechimers(samples)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if result.Offset != 10*time.Millisecond {
+		t.Errorf("Expected offset 10ms, got %v", result.Offset)
+	}
+	if len(result.Agreed) != 3 || len(result.Rejected) != 2 {
+		t.Errorf("Expected 3 agreed and 2 rejected, got %d and %d", len(result.Agreed), len(result.Rejected))
+	}
+
+	// Без большинства согласие невозможно
+	_, err = selectTruechimers(samples[2:4])
+	if err == nil {
+		t.Errorf("Expected error without majority agreement")
+	}
+}
+
+// Тест для JSON-отчёта с диагностикой серверов
+func TestBuildReport_JSON(t *testing.T) {
+	resp := &ntp.Response{
+		ClockOffset:    15 * time.Millisecond,
+		RTT:            30 * time.Millisecond,
+		Stratum:        1,
+		ReferenceID:    0x47505300, // "GPS"
+		RootDelay:      time.Millisecond,
+		RootDispersion: 2 * time.Millisecond,
+		Leap:           ntp.LeapAddSecond,
+	}
+	result := consensus{
+		Offset: resp.ClockOffset,
+		Agreed: []sample{{Server: "a", Offset: resp.ClockOffset, Response: resp}},
+	}
+
+	data, err := json.Marshal(buildReport(time.Now(), time.Now(), result, nil))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var decoded struct {
+		Offset int64 `json:"offset_ns"`
+		Agreed []struct {
+			Stratum     int    `json:"stratum"`
+			ReferenceID string `json:"reference_id"`
+			Leap        string `json:"leap"`
+			RTT         int64  `json:"rtt_ns"`
+		} `json:"agreed"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Expected valid JSON, got %v", err)
+	}
+	if decoded.Offset != int64(15*time.Millisecond) || len(decoded.Agreed) != 1 {
+		t.Fatalf("Unexpected report: %s", data)
+	}
+	server := decoded.Agreed[0]
+	if server.Stratum != 1 || server.ReferenceID != ".GPS." || server.Leap != "add second" || server.RTT != int64(30*time.Millisecond) {
+		t.Errorf("Unexpected server diagnostics: %s", data)
+	}
+}
+
+// Тест для оценки скорости дрейфа и порогов тревоги
+func TestDriftMonitor(t *testing.T) {
+	start := time.Now()
+	m := &driftMonitor{MaxOffset: time.Second, MaxDrift: 50, Window: 5}
+
+	// Смещение растёт на 10 мкс в секунду — дрейф 10 ppm
+	for i := 0; i < 5; i++ {
+		p := driftPoint{At: start.Add(time.Duration(i) * time.Second), Offset: time.Duration(i) * 10 * time.Microsecond}
+		if err := m.add(p); err != nil {
+			t.Fatalf("Unexpected alert: %v", err)
+		}
+	}
+	drift, ok := m.drift()
+	if !ok || drift < 9.99 || drift > 10.01 {
+		t.Errorf("Expected drift 10 ppm, got %v", drift)
+	}
+
+	// Скачок смещения превышает порог скорости дрейфа
+	if err := m.add(driftPoint{At: start.Add(5 * time.Second), Offset: 5 * time.Millisecond}); err == nil {
+		t.Errorf("Expected drift alert")
+	}
+
+	// Превышение порога абсолютного смещения
+	if err := m.add(driftPoint{At: start.Add(6 * time.Second), Offset: -2 * time.Second}); err == nil {
+		t.Errorf("Expected offset alert")
+	}
+}
+
+// Тест для режима мониторинга с обработчиком тревоги и без него
+func TestRunMonitor(t *testing.T) {
+	sources := []TimeSource{fakeSource{name: "a", offset: 2 * time.Second}}
+
+	// Без обработчика первая тревога завершает мониторинг
+	var out bytes.Buffer
+	m := &driftMonitor{MaxOffset: time.Second, Window: 10}
+	err := runMonitor(context.Background(), &out, sources, m, time.Millisecond, 3, "", false)
+	if err == nil {
+		t.Fatalf("Expected alert error, got none")
+	}
+	if lines := strings.Count(out.String(), "\n"); lines != 1 {
+		t.Errorf("Expected monitoring to stop after 1 poll, got %d", lines)
+	}
+
+	// С обработчиком мониторинг продолжается, а обработчик получает смещение
+	marker := filepath.Join(t.TempDir(), "alerts")
+	out.Reset()
+	m = &driftMonitor{MaxOffset: time.Second, Window: 10}
+	hook := "echo $CLOCK_OFFSET >> " + marker
+	if err := runMonitor(context.Background(), &out, sources, m, time.Millisecond, 3, hook, false); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	data, err := os.ReadFile(marker)
+	if err != nil {
+		t.Fatalf("Expected hook output, got %v", err)
+	}
+	if string(data) != "2s\n2s\n2s\n" {
+		t.Errorf("Expected hook to run 3 times, got %q", data)
+	}
+}
+
+// Тест мониторинга, в котором не удался ни один опрос
+func TestRunMonitor_AllPollsFailed(t *testing.T) {
+	timeoutErr := &net.OpError{Op: "read", Net: "udp", Err: os.ErrDeadlineExceeded}
+	sources := []TimeSource{fakeSource{name: "a", err: timeoutErr}}
+
+	var out bytes.Buffer
+	m := &driftMonitor{Window: 10}
+	err := runMonitor(context.Background(), &out, sources, m, time.Millisecond, 2, "", false)
+	if code := exitCode(err); code != exitTimeout {
+		t.Errorf("Expected exit code %d, got %d (%v)", exitTimeout, code, err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("Expected no output, got %q", out.String())
+	}
+}
+
+// Тест клиента против локального SNTP-сервера с заданным смещением
+func TestSNTPServer_Offset(t *testing.T) {
+	addr := startSNTPServer(t, &sntpServer{Offset: 3 * time.Second, Leap: ntp.LeapAddSecond})
+
+	_, result, err := getExactTime([]TimeSource{ntpSource{Server: addr}})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if diff := result.Offset - 3*time.Second; diff > 50*time.Millisecond || diff < -50*time.Millisecond {
+		t.Errorf("Expected offset about 3s, got %v", result.Offset)
+	}
+
+	resp := result.Agreed[0].Response
+	if resp.Stratum != 1 || resp.Leap != ntp.LeapAddSecond || resp.ReferenceString() != ".LOCL." {
+		t.Errorf("Unexpected response: stratum %d, leap %d, reference %s", resp.Stratum, resp.Leap, resp.ReferenceString())
+	}
+	if err := resp.Validate(); err != nil {
+		t.Errorf("Expected valid response, got %v", err)
+	}
+}
+
+// Тест kiss-of-death ответа локального SNTP-сервера
+func TestSNTPServer_KissOfDeath(t *testing.T) {
+	addr := startSNTPServer(t, &sntpServer{KissCode: "RATE"})
+
+	resp, err := ntp.Query(addr)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !resp.IsKissOfDeath() || resp.KissCode != "RATE" {
+		t.Errorf("Expected RATE kiss-of-death, got stratum %d, code %q", resp.Stratum, resp.KissCode)
+	}
+}
+
+// Тест источника времени по заголовку Date
+func TestHTTPDateSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Сервер спешит на минуту
+		w.Header().Set("Date", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	}))
+	defer server.Close()
+
+	s, err := httpDateSource{URL: server.URL}.Query()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if diff := s.Offset - time.Minute; diff > s.Distance || diff < -s.Distance {
+		t.Errorf("Expected offset about 1m within %v, got %v", s.Distance, s.Offset)
+	}
+}
+
+// Тест выбора и работы файлового и ручного источников
+func TestBuildSources(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "time")
+	reference := time.Now().Add(-time.Hour).Format(time.RFC3339Nano)
+	if err := os.WriteFile(path, []byte(reference+"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write time file: %v", err)
+	}
 
-	expectedErr := "NTP server error"
-	if err.Error() != expectedErr {
-		t.Errorf("Expected error %v, got %v", expectedErr, err.Error())
+	for _, kind := range []string{"file", "manual"} {
+		sources, err := buildSources(kind, nil, path, reference, ntp.AuthOptions{})
+		if err != nil {
+			t.Fatalf("Expected no error for %s source, got %v", kind, err)
+		}
+		s, err := sources[0].Query()
+		if err != nil {
+			t.Fatalf("Expected no error from %s source, got %v", kind, err)
+		}
+		if diff := s.Offset + time.Hour; diff > time.Second || diff < -time.Second {
+			t.Errorf("Expected offset about -1h from %s source, got %v", kind, s.Offset)
+		}
+	}
+
+	if _, err := buildSources("sundial", nil, "", "", ntp.AuthOptions{}); err == nil {
+		t.Errorf("Expected error for unknown source")
+	}
+}
+
+// Тест аутентификации запросов симметричным ключом
+func TestNTPAuthentication(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ntp.keys")
+	keysData := "# test keys\n1 SHA1 secret-key-one\n2 MD5 HEX:0123456789abcdef0123456789abcdef\n"
+	if err := os.WriteFile(path, []byte(keysData), 0600); err != nil {
+		t.Fatalf("Failed to write keys file: %v", err)
+	}
+	keys, err := loadKeys(path)
+	if err != nil {
+		t.Fatalf("Expected no error loading keys, got %v", err)
+	}
+	if len(keys) != 2 || keys[2].Type != ntp.AuthMD5 {
+		t.Fatalf("Unexpected keys: %v", keys)
+	}
+
+	addr := startSNTPServer(t, &sntpServer{Keys: keys})
+
+	// Сервер знает ключ — ответ подписан и принимается
+	for id := range keys {
+		sources, _ := buildSources("ntp", []string{addr}, "", "", keys[id])
+		if _, err := sources[0].Query(); err != nil {
+			t.Errorf("Expected authenticated response for key %d, got %v", id, err)
+		}
+	}
+
+	// Подменённый сервер без ключа — ответ отклоняется
+	spoofed := startSNTPServer(t, &sntpServer{})
+	sources, _ := buildSources("ntp", []string{spoofed}, "", "", keys[1])
+	_, _, err = getExactTime(sources)
+	if !errors.Is(err, errAuthFailed) || exitCode(err) != exitAuthFailed {
+		t.Errorf("Expected authentication failure, got %v", err)
+	}
+}
+
+// Тест кодов завершения для разных причин сбоя
+func TestExitCodes(t *testing.T) {
+	kod := startSNTPServer(t, &sntpServer{KissCode: "RATE"})
+	stratum16 := startSNTPServer(t, &sntpServer{Stratum: 16})
+	notInSync := startSNTPServer(t, &sntpServer{Leap: ntp.LeapNotInSync})
+	dnsErr := &net.DNSError{Err: "no such host", Name: "bad.invalid", IsNotFound: true}
+	timeoutErr := &net.OpError{Op: "read", Net: "udp", Err: os.ErrDeadlineExceeded}
+
+	tests := []struct {
+		name     string
+		sources  []TimeSource
+		expected int
+	}{
+		{"kiss of death", []TimeSource{ntpSource{Server: kod}}, exitKissOfDeath},
+		{"stratum 16", []TimeSource{ntpSource{Server: stratum16}}, exitUnsynchronized},
+		{"leap not in sync", []TimeSource{ntpSource{Server: notInSync}}, exitUnsynchronized},
+		{"dns", []TimeSource{fakeSource{name: "a", err: dnsErr}, fakeSource{name: "b", err: dnsErr}}, exitDNS},
+		{"timeout", []TimeSource{fakeSource{name: "a", err: timeoutErr}}, exitTimeout},
+		{"mixed", []TimeSource{fakeSource{name: "a", err: dnsErr}, fakeSource{name: "b", err: timeoutErr}}, exitFailure},
+	}
+
+	for _, test := range tests {
+		_, _, err := getExactTime(test.sources)
+		if code := exitCode(err); code != test.expected {
+			t.Errorf("%s: expected exit code %d, got %d (%v)", test.name, test.expected, code, err)
+		}
+	}
+
+	// Превышение порога смещения
+	if code := exitCode(checkOffset(-2*time.Second, time.Second)); code != exitOffset {
+		t.Errorf("Expected exit code %d for offset over threshold, got %d", exitOffset, code)
+	}
+	if code := exitCode(checkOffset(500*time.Millisecond, time.Second)); code != exitOK {
+		t.Errorf("Expected exit code %d for offset within threshold, got %d", exitOK, code)
 	}
 }
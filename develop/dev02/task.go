@@ -19,50 +19,427 @@ package main
 */
 
 import (
+	"bufio"
+	"bytes"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"math"
+	"os"
 	"strconv"
-	"unicode"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/rivo/uniseg"
+)
+
+// Ошибки распаковки
+var (
+	errInvalidString = errors.New("некорректная строка")
+	errOutputLimit   = errors.New("превышен максимальный размер результата")
+)
+
+// Максимальное значение многозначного счётчика
+const maxCount = math.MaxInt32
+
+// errorReason — причина некорректности строки
+type errorReason int
+
+const (
+	reasonLeadingDigit   errorReason = iota // цифра без предшествующего символа
+	reasonDanglingEscape                    // обратный слеш в конце строки
+	reasonCountOverflow                     // счётчик больше maxCount
 )
 
-// Функция распаковки строки
+func (r errorReason) String() string {
+	switch r {
+	case reasonLeadingDigit:
+		return "цифра без предшествующего символа"
+	case reasonDanglingEscape:
+		return "незавершённая escape-последовательность"
+	case reasonCountOverflow:
+		return "слишком большой счётчик повторов"
+	}
+	return "неизвестная ошибка"
+}
+
+// unpackError — ошибка разбора с позицией руны во входе (с нуля) и причиной.
+// Сопоставляется с errInvalidString через errors.Is.
+type unpackError struct {
+	Offset int
+	Reason errorReason
+}
+
+func (e *unpackError) Error() string {
+	return fmt.Sprintf("%v: %v (позиция %d)", errInvalidString, e.Reason, e.Offset)
+}
+
+func (e *unpackError) Is(target error) bool {
+	return target == errInvalidString
+}
+
+// options — настройки грамматики и ограничений
+type options struct {
+	MaxOutput  int64 // максимальный размер результата в байтах (0 — без ограничения)
+	MultiDigit bool  // многозначные счётчики: "a12" — 12 символов a
+	Graphemes  bool  // повторять кластеры графем целиком, а не отдельные руны
+}
+
+// Функция распаковки строки; нулевой счётчик удаляет предшествующий символ
 func unpackString(str string) (string, error) {
-	runes := []rune(str)
-	var result []rune
-	escape := false // Флаг для эскейп последовательностей
+	return unpackWithOptions(str, options{})
+}
+
+// Распаковка строки с заданными настройками
+func unpackWithOptions(str string, opts options) (string, error) {
+	var result strings.Builder
+	if _, err := unpackStream(strings.NewReader(str), &result, opts); err != nil {
+		return "", err
+	}
+	return result.String(), nil
+}
+
+// Потоковая распаковка из r в w. Возвращает число записанных байт;
+// уже распакованная часть записывается даже при ошибке.
+func unpackStream(r io.Reader, w io.Writer, opts options) (int64, error) {
+	return io.Copy(w, newUnpackReader(r, opts))
+}
+
+// tokenReader делит вход на токены грамматики: отдельные руны или, в режиме
+// Graphemes, расширенные кластеры графем (UAX #29), чтобы "é" из e и
+// комбинируемого акцента или эмодзи с модификатором повторялись целиком.
+type tokenReader struct {
+	src       io.Reader
+	graphemes bool
+	buf       []byte // прочитанные, но ещё не разобранные данные
+	state     int    // состояние сегментатора графем
+	eof       bool
+}
+
+// Создание разборщика токенов
+func newTokenReader(r io.Reader, graphemes bool) *tokenReader {
+	return &tokenReader{src: r, graphemes: graphemes, state: -1}
+}
+
+// Чтение очередной порции входа
+func (t *tokenReader) fill() error {
+	var chunk [4096]byte
+	n, err := t.src.Read(chunk[:])
+	t.buf = append(t.buf, chunk[:n]...)
+	if err == io.EOF {
+		t.eof = true
+		return nil
+	}
+	return err
+}
+
+// Следующий токен; io.EOF по окончании входа
+func (t *tokenReader) next() ([]byte, error) {
+	for {
+		if len(t.buf) > 0 && (t.eof || utf8.FullRune(t.buf)) {
+			if !t.graphemes {
+				ch, size := utf8.DecodeRune(t.buf)
+				t.buf = t.buf[size:]
+				return []byte(string(ch)), nil // некорректные байты заменяются на U+FFFD
+			}
+
+			// Граница кластера известна, только если за ним есть целая руна или вход закончился
+			cluster, rest, _, state := uniseg.FirstGraphemeCluster(t.buf, t.state)
+			if t.eof || (len(rest) > 0 && utf8.FullRune(rest)) {
+				t.buf, t.state = rest, state
+				return append([]byte(nil), cluster...), nil
+			}
+		}
+		if t.eof {
+			if len(t.buf) == 0 {
+				return nil, io.EOF
+			}
+			continue
+		}
+		if err := t.fill(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// unpackReader — потоковый распаковщик: читает упакованные данные из источника
+// и отдаёт распакованный результат порциями через Read. В памяти хранится только
+// последний токен и число его оставшихся повторов, поэтому расход памяти не зависит
+// от размера входа. Ограничение MaxOutput защищает от «бомб» вида "a9a9a9...".
+type unpackReader struct {
+	src     *tokenReader
+	opts    options
+	written int64 // байт запланировано к выводу
+	pos     int   // позиция следующей руны входа
+
+	pending   []byte // последний токен, к которому может относиться счётчик
+	escape    bool   // флаг для эскейп последовательностей
+	escapePos int    // позиция начала эскейп последовательности
+	counting  bool   // читается многозначный счётчик
+	count     int    // значение счётчика
+
+	repeat     []byte // токен, который нужно вывести
+	repeatLeft int    // сколько раз его ещё нужно вывести
+	partial    []byte // байты токена, не поместившиеся в предыдущий буфер
+	err        error  // ошибка или io.EOF, возвращаемая после вывода всех данных
+}
+
+// Создание потокового распаковщика
+func newUnpackReader(r io.Reader, opts options) *unpackReader {
+	return &unpackReader{src: newTokenReader(r, opts.Graphemes), opts: opts}
+}
+
+// Read заполняет p распакованными данными
+func (u *unpackReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		switch {
+		case len(u.partial) > 0:
+			copied := copy(p[n:], u.partial)
+			u.partial = u.partial[copied:]
+			n += copied
+		case u.repeatLeft > 0:
+			u.partial = u.repeat
+			u.repeatLeft--
+		case u.err != nil:
+			if n > 0 {
+				return n, nil
+			}
+			return 0, u.err
+		default:
+			u.err = u.step()
+		}
+	}
+	return n, nil
+}
+
+// Планирование вывода токена count раз с учётом ограничения размера
+func (u *unpackReader) emit(token []byte, count int) error {
+	size := int64(len(token)) * int64(count)
+	if u.opts.MaxOutput > 0 && u.written+size > u.opts.MaxOutput {
+		return errOutputLimit
+	}
+	u.written += size
+	u.repeat, u.repeatLeft = token, count
+	return nil
+}
 
-	for i := 0; i < len(runes); i++ {
-		if runes[i] == '\\' && !escape { // Если это первый символ обратного слеша
-			escape = true
+// Вывод отложенного токена: с накопленным счётчиком или один раз
+func (u *unpackReader) flushPending() error {
+	if u.pending == nil {
+		return nil
+	}
+	count := 1
+	if u.counting {
+		count = u.count
+	}
+	token := u.pending
+	u.pending, u.counting = nil, false
+	return u.emit(token, count)
+}
+
+// Обработка очередных токенов входа до появления данных для вывода.
+// Возвращает io.EOF по окончании входа или ошибку распаковки.
+func (u *unpackReader) step() error {
+	for {
+		token, err := u.src.next()
+		if err == io.EOF {
+			if u.escape { // Обратный слеш в конце строки
+				return &unpackError{Offset: u.escapePos, Reason: reasonDanglingEscape}
+			}
+			if u.pending != nil {
+				return u.flushPending()
+			}
+			return io.EOF
+		}
+		if err != nil {
+			return err
+		}
+		pos := u.pos
+		u.pos += utf8.RuneCount(token)
+
+		if isBackslash(token) && !u.escape { // Если это первый символ обратного слеша
+			u.escape, u.escapePos = true, pos
 			continue
 		}
 
-		// Если это цифра и мы не в режиме экранирования
-		if unicode.IsDigit(runes[i]) && !escape {
-			if i == 0 || unicode.IsDigit(runes[i-1]) { // Проверяем на некорректную строку
-				return "", errors.New("некорректная строка")
+		// Если это цифра и мы не в режиме экранирования — это счётчик повторов
+		if isDigitToken(token) && !u.escape {
+			digit := int(token[0] - '0')
+			if u.counting { // Продолжение многозначного счётчика
+				if u.count > (maxCount-digit)/10 {
+					return &unpackError{Offset: pos, Reason: reasonCountOverflow}
+				}
+				u.count = u.count*10 + digit
+				continue
+			}
+			if u.pending == nil { // Цифра в начале строки или сразу после счётчика
+				return &unpackError{Offset: pos, Reason: reasonLeadingDigit}
+			}
+			u.counting, u.count = true, digit
+			if u.opts.MultiDigit {
+				continue
 			}
+			return u.flushPending()
+		}
+
+		// Обычный или экранированный токен: выводим предыдущий и запоминаем текущий
+		hadPending := u.pending != nil
+		err = u.flushPending()
+		u.pending = token
+		u.escape = false // Сбрасываем флаг экранирования
+		if hadPending {
+			return err
+		}
+	}
+}
+
+// Функция упаковки строки — обратная к unpackString
+func packString(str string) string {
+	return packWithOptions(str, options{})
+}
+
+// Упаковка строки в грамматике, заданной настройками
+func packWithOptions(str string, opts options) string {
+	var result strings.Builder
+	packStream(strings.NewReader(str), &result, opts)
+	return result.String()
+}
+
+// Потоковая упаковка из r в w. Строит кратчайшую строку той же грамматики:
+// цифры и обратные слеши экранируются, серии одинаковых токенов записываются
+// со счётчиком, если это не удлиняет запись. Без MultiDigit серии разбиваются
+// на блоки не длиннее 9.
+func packStream(r io.Reader, w io.Writer, opts options) error {
+	tokens := newTokenReader(r, opts.Graphemes)
+	writer := bufio.NewWriter(w)
 
-			// Конвертируем символ в число
-			count, _ := strconv.Atoi(string(runes[i]))
-			for j := 0; j < count-1; j++ { // Повторяем предыдущий символ count раз
-				result = append(result, runes[i-1])
+	var current []byte
+	run := 0
+	for {
+		token, err := tokens.next()
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if err == nil && run > 0 && bytes.Equal(token, current) {
+			run++
+			continue
+		}
+		if run > 0 {
+			var next []byte
+			if err == nil {
+				next = token
 			}
-		} else {
-			result = append(result, runes[i]) // Добавляем символ в результат
-			escape = false                    // Сбрасываем флаг экранирования
+			writeRun(writer, current, run, next, opts)
+		}
+		if err == io.EOF {
+			break
+		}
+		current, run = token, 1
+	}
+
+	return writer.Flush()
+}
+
+// Запись серии из count одинаковых токенов, за которой следует токен next
+// (nil в конце потока)
+func writeRun(w *bufio.Writer, token []byte, count int, next []byte, opts options) {
+	if isDigitToken(token) || isBackslash(token) {
+		token = append([]byte{'\\'}, token...)
+	}
+	unit := utf8.RuneCount(token)
+
+	// После управляющего символа кластер может начинаться с комбинирующей
+	// метки, которая слилась бы с цифрой счётчика; последний токен серии
+	// тогда пишем без счётчика
+	tail := 0
+	if opts.Graphemes && next != nil && joinsDigit(next) {
+		tail = 1
+		count--
+	}
+
+	limit := 9
+	if opts.MultiDigit {
+		limit = maxCount
+	}
+
+	for count > 0 {
+		chunk := count
+		if chunk > limit {
+			chunk = limit
+		}
+		count -= chunk
+
+		// Счётчик добавляет свои цифры; используем его, если запись не длиннее
+		digits := strconv.Itoa(chunk)
+		if chunk > 1 && unit+len(digits) <= chunk*unit {
+			w.Write(token)
+			w.WriteString(digits)
+			continue
 		}
+		for i := 0; i < chunk; i++ {
+			w.Write(token)
+		}
+	}
+	for ; tail > 0; tail-- {
+		w.Write(token)
 	}
+}
 
-	return string(result), nil
+// Проверка, сливается ли токен в один кластер с предшествующей цифрой
+func joinsDigit(token []byte) bool {
+	cluster, _, _, _ := uniseg.FirstGraphemeCluster(append([]byte{'0'}, token...), -1)
+	return len(cluster) > 1
+}
+
+// Проверка, является ли токен десятичной цифрой
+func isDigitToken(token []byte) bool {
+	return len(token) == 1 && token[0] >= '0' && token[0] <= '9'
+}
+
+// Проверка, является ли токен обратным слешем
+func isBackslash(token []byte) bool {
+	return len(token) == 1 && token[0] == '\\'
 }
 
 func main() {
-	// Пример использования
-	res, err := unpackString("qwe\\4\\5")
-	if err != nil {
-		fmt.Println("Error:", err)
-	} else {
-		fmt.Println("Result:", res)
+	maxOutput := flag.Int64("max-output", 1<<30, "Maximum output size in bytes (0 means unlimited)")
+	pack := flag.Bool("pack", false, "Pack instead of unpacking")
+	multiDigit := flag.Bool("multi-digit", false, "Allow multi-digit repeat counts (a12 -> 12 a's)")
+	graphemes := flag.Bool("graphemes", false, "Repeat whole grapheme clusters instead of single runes")
+	flag.Parse()
+
+	opts := options{MaxOutput: *maxOutput, MultiDigit: *multiDigit, Graphemes: *graphemes}
+	if *pack {
+		for _, arg := range flag.Args() {
+			fmt.Println("Result:", packWithOptions(arg, opts))
+		}
+		if flag.NArg() == 0 {
+			if err := packStream(os.Stdin, os.Stdout, opts); err != nil {
+				fmt.Fprintln(os.Stderr, "Error:", err)
+				os.Exit(1)
+			}
+		}
+		return
+	}
+
+	// Строки из аргументов распаковываем по отдельности
+	if flag.NArg() > 0 {
+		for _, arg := range flag.Args() {
+			res, err := unpackWithOptions(arg, opts)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error:", err)
+				os.Exit(1)
+			}
+			fmt.Println("Result:", res)
+		}
+		return
+	}
+
+	// Без аргументов работаем как фильтр: stdin -> stdout
+	if _, err := unpackStream(os.Stdin, os.Stdout, opts); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
 	}
 }
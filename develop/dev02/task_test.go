@@ -1,7 +1,14 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"testing/quick"
+	"unicode/utf8"
 )
 
 func TestUnpackString(t *testing.T) {
@@ -12,26 +19,256 @@ func TestUnpackString(t *testing.T) {
 	}{
 		{"a4bc2d5e", "aaaabccddddde", false},
 		{"abcd", "abcd", false},
-		{"45", "", true},                  // Неверный формат
-		{"", "", false},                   // Пустая строка
-		{"qwe\\4\\5", "qwe45", false},     // Экранирование цифр
-		{"qwe\\\\5", "qwe\\\\\\\\\\", false},  // Экранирование слеша
+		{"45", "", true},                     // Неверный формат
+		{"", "", false},                      // Пустая строка
+		{"qwe\\4\\5", "qwe45", false},        // Экранирование цифр
+		{"qwe\\\\5", "qwe\\\\\\\\\\", false}, // Экранирование слеша
+		{"a0b", "b", false},                  // Нулевой счётчик удаляет символ
 	}
 
 	for _, test := range tests {
 		result, err := unpackString(test.input)
 		if test.err {
 			if err == nil {
-				t.Logf("Expected error for input %s, but got nil", test.input)
+				t.Errorf("Expected error for input %s, but got nil", test.input)
 			} else {
 				t.Logf("Correctly received error for input %s: %v", test.input, err)
 			}
 		} else {
 			if err != nil {
-				t.Logf("Unexpected error for input %s: %v", test.input, err)
+				t.Errorf("Unexpected error for input %s: %v", test.input, err)
 			} else if result != test.expected {
-				t.Logf("For input %s, expected %s, but got %s", test.input, test.expected, result)
+				t.Errorf("For input %s, expected %s, but got %s", test.input, test.expected, result)
 			}
 		}
 	}
 }
+
+// Тест потоковой распаковки, в том числе при чтении по одному байту
+func TestUnpackStream(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"a4bc2d5e", "aaaabccddddde"},
+		{"qwe\\45", "qwe44444"},
+		{"qwe\\4\\5", "qwe45"},
+		{"я3ё2\n", "яяяёё\n"},
+	}
+
+	for _, test := range tests {
+		var out bytes.Buffer
+		reader := iotest.OneByteReader(strings.NewReader(test.input))
+		n, err := io.Copy(&out, iotest.OneByteReader(newUnpackReader(reader, options{})))
+		if err != nil {
+			t.Errorf("For input %q unexpected error: %v", test.input, err)
+			continue
+		}
+		if out.String() != test.expected || n != int64(len(test.expected)) {
+			t.Errorf("For input %q expected %q, got %q (%d bytes)", test.input, test.expected, out.String(), n)
+		}
+	}
+}
+
+// Тест ограничения размера результата
+func TestUnpackStream_OutputLimit(t *testing.T) {
+	bomb := strings.Repeat("a9", 1000)
+
+	var out bytes.Buffer
+	_, err := unpackStream(strings.NewReader(bomb), &out, options{MaxOutput: 100})
+	if !errors.Is(err, errOutputLimit) {
+		t.Fatalf("Expected output limit error, got %v", err)
+	}
+	if out.Len() > 100 {
+		t.Errorf("Expected at most 100 bytes of output, got %d", out.Len())
+	}
+
+	out.Reset()
+	if _, err := unpackStream(strings.NewReader(bomb), &out, options{MaxOutput: 9000}); err != nil || out.Len() != 9000 {
+		t.Errorf("Expected 9000 bytes without error, got %d bytes and %v", out.Len(), err)
+	}
+}
+
+// Тест упаковки строки
+func TestPackString(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"aaaabccddddde", "a4bc2d5e"},
+		{"abcd", "abcd"},
+		{"", ""},
+		{"qwe45", "qwe\\4\\5"},
+		{"qwe44444", "qwe\\45"},
+		{"qwe\\\\\\\\\\", "qwe\\\\5"},
+		{"aa", "a2"},
+		{"\\\\", "\\\\2"},
+		{strings.Repeat("я", 10), "я9я"},
+		{strings.Repeat("b", 20), "b9b9b2"},
+	}
+
+	for _, test := range tests {
+		if result := packString(test.input); result != test.expected {
+			t.Errorf("For input %q expected %q, got %q", test.input, test.expected, result)
+		}
+	}
+}
+
+// Свойство: распаковка упакованной строки возвращает исходную строку
+// во всех вариантах грамматики
+func checkRoundTrip(s string) bool {
+	for _, opts := range []options{{}, {MultiDigit: true}, {Graphemes: true}, {Graphemes: true, MultiDigit: true}} {
+		unpacked, err := unpackWithOptions(packWithOptions(s, opts), opts)
+		if err != nil || unpacked != s {
+			return false
+		}
+	}
+	return true
+}
+
+// Проверка свойства на случайных строках Unicode
+func TestPackUnpackRoundTrip(t *testing.T) {
+	if err := quick.Check(checkRoundTrip, &quick.Config{MaxCount: 2000}); err != nil {
+		t.Error(err)
+	}
+
+	// Комбинирующая метка после управляющего символа не должна сливаться со счётчиком
+	for _, s := range []string{"\n\n\u0301", "\t\t\u200d", "\r\r\r\u0301", strings.Repeat("\n", 12) + "\u0301"} {
+		if !checkRoundTrip(s) {
+			t.Errorf("Round trip failed for %q", s)
+		}
+	}
+
+	// Случайные строки редко содержат серии и цифры, поэтому проверяем и их
+	runs := func(parts []rune, counts []uint8) bool {
+		var b strings.Builder
+		alphabet := []rune{'a', '7', '\\', 'ё', '😀', '\u0301', '\n', '\u200d'}
+		for i, r := range parts {
+			ch := alphabet[int(uint32(r)%uint32(len(alphabet)))]
+			count := 1
+			if i < len(counts) {
+				count += int(counts[i] % 25)
+			}
+			b.WriteString(strings.Repeat(string(ch), count))
+		}
+		return checkRoundTrip(b.String())
+	}
+	if err := quick.Check(runs, &quick.Config{MaxCount: 2000}); err != nil {
+		t.Error(err)
+	}
+}
+
+// Фаззинг свойства распаковки упакованной строки: go test -fuzz=FuzzPackUnpack
+func FuzzPackUnpack(f *testing.F) {
+	for _, seed := range []string{"", "a4bc2d5e", "qwe\\45", "1234567890", "\\\\\\", "ééé😀😀", strings.Repeat("x", 30), "\n\n\u0301", "\t\t\u200d"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		if !utf8.ValidString(s) {
+			t.Skip()
+		}
+		packed := packString(s)
+		if !checkRoundTrip(s) {
+			t.Errorf("Round trip failed for %q (packed %q)", s, packed)
+		}
+		if utf8.RuneCountInString(packed) > 2*utf8.RuneCountInString(s) {
+			t.Errorf("Packed %q is longer than escaping every rune", packed)
+		}
+	})
+}
+
+// Тест многозначных счётчиков
+func TestUnpackMultiDigit(t *testing.T) {
+	opts := options{MultiDigit: true}
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"a12", strings.Repeat("a", 12)},
+		{"a10b", "aaaaaaaaaab"},
+		{"a0b", "b"},
+		{"\\123", strings.Repeat("1", 23)},
+		{"я100", strings.Repeat("я", 100)},
+	}
+	for _, test := range tests {
+		result, err := unpackWithOptions(test.input, opts)
+		if err != nil || result != test.expected {
+			t.Errorf("For input %q expected %q, got %q (%v)", test.input, test.expected, result, err)
+		}
+	}
+
+	if packed := packWithOptions(strings.Repeat("b", 120), opts); packed != "b120" {
+		t.Errorf("Expected b120, got %q", packed)
+	}
+}
+
+// Тест ошибок с позицией и причиной
+func TestUnpackErrors(t *testing.T) {
+	tests := []struct {
+		input  string
+		opts   options
+		offset int
+		reason errorReason
+	}{
+		{"45", options{}, 0, reasonLeadingDigit},
+		{"a12", options{}, 2, reasonLeadingDigit},
+		{"ёж\\", options{}, 2, reasonDanglingEscape},
+		{"ab\\\\\\", options{}, 4, reasonDanglingEscape},
+		{"a99999999999", options{MultiDigit: true}, 10, reasonCountOverflow},
+		{"7a", options{MultiDigit: true}, 0, reasonLeadingDigit},
+	}
+
+	for _, test := range tests {
+		_, err := unpackWithOptions(test.input, test.opts)
+		var unpackErr *unpackError
+		if !errors.As(err, &unpackErr) {
+			t.Errorf("For input %q expected unpackError, got %v", test.input, err)
+			continue
+		}
+		if unpackErr.Offset != test.offset || unpackErr.Reason != test.reason {
+			t.Errorf("For input %q expected %v at %d, got %v at %d",
+				test.input, test.reason, test.offset, unpackErr.Reason, unpackErr.Offset)
+		}
+		if !errors.Is(err, errInvalidString) {
+			t.Errorf("For input %q expected error to match errInvalidString", test.input)
+		}
+	}
+}
+
+// Тест повторения кластеров графем целиком
+func TestUnpackGraphemes(t *testing.T) {
+	const (
+		decomposedE = "e\u0301"         // e + комбинируемый акцент
+		thumbsUp    = "👍🏽"              // эмодзи с модификатором цвета кожи
+		family      = "👩\u200d👩\u200d👧" // последовательность с ZWJ
+	)
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{decomposedE + "3", strings.Repeat(decomposedE, 3)},
+		{thumbsUp + "2x", thumbsUp + thumbsUp + "x"},
+		{"a" + family + "2", "a" + family + family},
+		{"\\" + decomposedE + "2", decomposedE + decomposedE},
+		{"🇷🇺🇰🇿2", "🇷🇺🇰🇿🇰🇿"},
+	}
+
+	for _, test := range tests {
+		result, err := unpackWithOptions(test.input, options{Graphemes: true})
+		if err != nil || result != test.expected {
+			t.Errorf("For input %q expected %q, got %q (%v)", test.input, test.expected, result, err)
+		}
+	}
+
+	// В режиме рун повторяется только последняя руна кластера
+	if result, _ := unpackString(decomposedE + "3"); result != "e\u0301\u0301\u0301" {
+		t.Errorf("Expected only the accent to be repeated in rune mode, got %q", result)
+	}
+
+	// Смещение в ошибке по-прежнему считается в рунах
+	_, err := unpackWithOptions(decomposedE+"12", options{Graphemes: true})
+	var unpackErr *unpackError
+	if !errors.As(err, &unpackErr) || unpackErr.Offset != 3 {
+		t.Errorf("Expected error at rune offset 3, got %v", err)
+	}
+}
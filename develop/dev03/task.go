@@ -8,10 +8,10 @@ package main
 
 Поддержать ключи
 
--k — указание колонки для сортировки
+-k — указание колонки для сортировки (POS1[,POS2][OPTS], можно повторять)
 -n — сортировать по числовому значению
 -r — сортировать в обратном порядке
--u — не выводить повторяющиеся строки
+-u — не выводить повторяющиеся строки (из строк с равными ключами выводится первая)
 
 Дополнительное
 
@@ -20,287 +20,1075 @@ package main
 -M — сортировать по названию месяца
 -b — игнорировать хвостовые пробелы
 -c — проверять отсортированы ли данные
+-C — проверять отсортированы ли данные без вывода сообщения
 -h — сортировать по числовому значению с учётом суффиксов
+-g — сортировать по значению чисел с плавающей точкой (в том числе inf, nan)
+-t — разделитель полей (по умолчанию поля разделены пробелами)
+-m — слить уже отсортированные файлы без сортировки
+-s — устойчивая сортировка (без сравнения строк целиком при равных ключах)
+-o — файл для результата (может совпадать с входным файлом)
+-V — естественная сортировка номеров версий (file2 раньше file10)
+-R — случайный порядок: строки с равными ключами идут подряд, группы перемешаны
+--seed, --random-source — источник случайности для -R (для воспроизводимого порядка)
+--csv — разбирать вход как CSV (RFC 4180), ключи -k задают номера или имена колонок
+--header — первая запись CSV — заголовок, он остаётся первой строкой результата
+--parallel — число горутин для сортировки в памяти
+-locale — сравнение строк по правилам Unicode Collation Algorithm для языка (ru, en, ...)
+-S — размер буфера в памяти; при превышении используется внешняя сортировка
+-T — каталог для временных файлов внешней сортировки
+
+Без аргументов читается стандартный ввод, результат пишется в стандартный вывод.
 
 Программа должна проходить все тесты. Код должен проходить проверки go vet и golint.
 */
 
 import (
 	"bufio"
+	"bytes"
+	"cmp"
+	"container/heap"
+	"crypto/rand"
+	"encoding/csv"
+	"errors"
 	"flag"
 	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
 	"os"
 	"regexp"
+	"runtime"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
 )
 
 var (
-	sortByColumnStr      string
+	sortKeys             keyList
 	numericSort          bool
 	reverseSort          bool
 	unique               bool
 	monthSort            bool
 	ignoreTrailingSpaces bool
 	checkSorted          bool
+	checkQuiet           bool
+	mergeOnly            bool
 	humanReadableSort    bool
+	generalNumericSort   bool
+	bufferSize           string
+	tempDir              string
+	fieldSeparator       string
+	stableSort           bool
+	outputPath           string
+	versionSort          bool
+	randomSort           bool
+	randomSeed           string
+	randomSource         string
+	locale               string
+	parallel             int
+	csvMode              bool
+	csvHeader            bool
 )
 
 func init() {
 	// Определение флагов для командной строки
-	flag.StringVar(&sortByColumnStr, "k", "1", "Sort by column (default is the first column)")
-	flag.BoolVar(&numericSort, "n", false, "Sort by numeric value")
+	flag.Var(&sortKeys, "k", "Sort key POS1[,POS2][OPTS], where POS is F[.C] and OPTS are b, g, h, M, n, R, r, V (repeatable)")
+	flag.BoolVar(&numericSort, "n", false, "Sort by numeric value (sign, decimals, thousands separators)")
 	flag.BoolVar(&reverseSort, "r", false, "Sort in reverse order")
-	flag.BoolVar(&unique, "u", false, "Suppress duplicate lines")
+	flag.BoolVar(&unique, "u", false, "Output only the first of lines with equal keys")
 	flag.BoolVar(&monthSort, "M", false, "Sort by month names")
-	flag.BoolVar(&ignoreTrailingSpaces, "b", false, "Ignore trailing spaces")
-	flag.BoolVar(&checkSorted, "c", false, "Check if data is sorted")
-	flag.BoolVar(&humanReadableSort, "h", false, "Sort by numeric value considering suffixes")
+	flag.BoolVar(&ignoreTrailingSpaces, "b", false, "Ignore leading blanks")
+	flag.BoolVar(&checkSorted, "c", false, "Check if data is sorted; report the first disorder and exit with status 1")
+	flag.BoolVar(&checkQuiet, "C", false, "Like -c, but do not report the first disorder")
+	flag.BoolVar(&mergeOnly, "m", false, "Merge already sorted files without sorting")
+	flag.BoolVar(&humanReadableSort, "h", false, "Sort by numeric value considering suffixes (2K, 1.5M, 1Gi)")
+	flag.BoolVar(&generalNumericSort, "g", false, "Sort by general floating-point value (1e3, inf, nan)")
+	flag.StringVar(&bufferSize, "S", "", "Memory buffer size (e.g. 100M); larger inputs are sorted externally")
+	flag.StringVar(&tempDir, "T", os.TempDir(), "Directory for temporary files of external sort")
+	flag.StringVar(&fieldSeparator, "t", "", "Field separator character (e.g. ':' or '\\t'); fields are blank-separated by default")
+	flag.BoolVar(&stableSort, "s", false, "Stable sort: keep input order of lines with equal keys")
+	flag.StringVar(&outputPath, "o", "", "Write result to file instead of standard output (may be one of the inputs)")
+	flag.BoolVar(&versionSort, "V", false, "Natural sort of version numbers within text")
+	flag.BoolVar(&randomSort, "R", false, "Shuffle lines, keeping lines with equal keys together")
+	flag.StringVar(&randomSeed, "seed", "", "Seed for -R; the same seed gives the same order")
+	flag.StringVar(&randomSource, "random-source", "", "File to read random bytes for -R from")
+	flag.BoolVar(&csvMode, "csv", false, "Parse input as RFC 4180 CSV; -k selects columns by number or name (-k name:OPTS)")
+	flag.BoolVar(&csvHeader, "header", false, "With --csv, keep the first record as a header at the top")
+	flag.IntVar(&parallel, "parallel", min(runtime.NumCPU(), 8), "Number of goroutines used to sort in memory")
+	flag.StringVar(&locale, "locale", "", "Compare text using Unicode collation rules for the language (e.g. ru, en); byte order by default")
 }
 
 func main() {
-	// Проверяем, что первый аргумент — это команда "sort"
-	if len(os.Args) < 2 || os.Args[1] != "sort" {
-		printUsage()
-		os.Exit(1)
-	}
+	flag.Usage = printUsage
+	flag.CommandLine.Parse(splitAttachedValues(os.Args[1:]))
 
-	// Создание файла input.txt, если он не существует
-	ensureInputFileExists()
+	separator, err := parseSeparator(fieldSeparator)
+	if err != nil {
+		fatal(fmt.Errorf("invalid field separator: %w", err))
+	}
 
-	// Парсим флаги, начиная со второго аргумента
-	flag.CommandLine.Parse(os.Args[2:])
+	collation, err := newCollation(locale)
+	if err != nil {
+		fatal(err)
+	}
 
-	// Проверка значения флага -k
-	sortByColumn, err := strconv.Atoi(sortByColumnStr)
+	salt, err := randomSalt(randomSeed, randomSource)
 	if err != nil {
-		fmt.Println("Column number was not set. Using default column 1.")
-		sortByColumn = 1
+		fatal(err)
 	}
 
-	// Получаем имя файла из аргументов командной строки
-	var inputFile string
-	if len(flag.Args()) > 0 {
-		inputFile = flag.Args()[0]
-	} else {
-		inputFile = "input.txt"
+	config := sortConfig{
+		Keys:      sortKeys,
+		Separator: separator,
+		Stable:    stableSort,
+		Collation: collation,
+		Salt:      salt,
+		Global: sortOptions{
+			Numeric:         numericSort,
+			Version:         versionSort,
+			Random:          randomSort,
+			Human:           humanReadableSort,
+			General:         generalNumericSort,
+			Month:           monthSort,
+			Reverse:         reverseSort,
+			IgnoreBlanks:    ignoreTrailingSpaces,
+			IgnoreEndBlanks: ignoreTrailingSpaces,
+		},
 	}
 
-	// Чтение строк из файла
-	lines, err := readLines(inputFile)
-	if err != nil {
-		fmt.Println("Error reading file:", err)
-		os.Exit(1)
+	// Размер буфера для внешней сортировки (флаг -S)
+	var budget int64
+	if bufferSize != "" {
+		budget, err = parseSize(bufferSize)
+		if err != nil {
+			fatal(fmt.Errorf("invalid buffer size: %w", err))
+		}
 	}
 
-	// Проверка отсортированности (флаг -c)
-	if checkSorted {
-		if isSorted(lines, sortByColumn, numericSort, ignoreTrailingSpaces, humanReadableSort, monthSort) {
-			fmt.Println("The data is sorted.")
-		} else {
-			fmt.Println("The data is not sorted.")
+	// Имена колонок в ключах допустимы только для CSV с заголовком
+	for _, key := range sortKeys {
+		if key.Name != "" && !(csvMode && csvHeader) {
+			fatal(fmt.Errorf("column name %q in -k requires --csv and --header", key.Name))
+		}
+	}
+	if csvMode && (checkSorted || checkQuiet || mergeOnly) {
+		fatal(fmt.Errorf("--csv cannot be combined with -c, -C or -m"))
+	}
+
+	// Входные файлы; без аргументов или "-" — стандартный ввод
+	input := &inputSource{names: flag.Args()}
+	if len(input.names) == 0 {
+		input.names = []string{"-"}
+	}
+	defer input.close()
+
+	// Проверка отсортированности (флаги -c и -C): при нарушении порядка
+	// код завершения 1
+	if checkSorted || checkQuiet {
+		if len(input.names) > 1 {
+			fatal(fmt.Errorf("extra operand %q not allowed with -c", input.names[1]))
+		}
+		name := input.names[0]
+		number, line, err := findDisorder(input, config, unique)
+		if err != nil {
+			fatal(err)
+		}
+		if number > 0 {
+			if !checkQuiet {
+				fmt.Fprintf(os.Stderr, "sort: %s:%d: disorder: %s\n", name, number, line)
+			}
+			os.Exit(1)
 		}
-		// Завершаем выполнение программы, если включен режим проверки отсортированности
 		return
 	}
 
-	// Сортировка данных (даже при флаге -c мы производим запись отсортированных данных)
-	if unique {
-		lines = removeDuplicates(lines)
+	// Результат пишется в стандартный вывод или в файл (флаг -o)
+	var output io.WriteCloser = os.Stdout
+	if outputPath != "" {
+		output = &outputFile{name: outputPath}
 	}
+	writer := bufio.NewWriter(output)
 
-	if monthSort {
-		sort.Slice(lines, func(i, j int) bool {
-			return monthIndex(lines[i]) < monthIndex(lines[j])
-		})
-	} else {
-		sort.Slice(lines, func(i, j int) bool {
-			return compareLines(lines[i], lines[j], sortByColumn, numericSort, ignoreTrailingSpaces, humanReadableSort) < 0
-		})
+	switch {
+	case csvMode:
+		if len(input.names) > 1 {
+			fatal(fmt.Errorf("--csv accepts a single input"))
+		}
+		err = sortCSVFile(input.names[0], writer, config, csvHeader, unique, parallel)
+	case mergeOnly:
+		err = mergeInputs(input.names, writer, outputPath, tempDir, config, unique)
+	default:
+		err = sortInput(input, writer, config, budget, unique, parallel)
+	}
+	if err != nil {
+		fatal(err)
+	}
+	if err := writer.Flush(); err != nil {
+		fatal(err)
+	}
+	if err := output.Close(); err != nil {
+		fatal(err)
 	}
+}
+
+// Вывод ошибки в STDERR и завершение программы
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "sort:", err)
+	os.Exit(2)
+}
 
-	if reverseSort {
-		sort.Sort(sort.Reverse(sort.StringSlice(lines)))
+// Сортировка входа целиком в памяти в workers горутин или, если задан бюджет
+// памяти (флаг -S), внешней сортировкой слиянием
+func sortInput(input lineSource, output io.Writer, config sortConfig, budget int64, unique bool, workers int) error {
+	if unique {
+		// Строки сравниваются только по ключам; сортировка устойчива,
+		// поэтому из строк с равными ключами первой остаётся первая во входе
+		config.Stable = true
+	}
+	if budget > 0 {
+		return externalSort(input, output, budget, tempDir, config.compare, unique)
 	}
 
-	// Запись отсортированных строк в новый файл (всегда перезаписываем данные)
-	outputFile := "sorted_" + inputFile
-	err = writeLines(outputFile, lines)
+	lines, err := collectLines(input)
 	if err != nil {
-		fmt.Println("Error writing file:", err)
-		os.Exit(1)
+		return err
 	}
-	fmt.Println("Sorted data written to", outputFile)
+	parallelSort(lines, config, workers)
+	return writeSorted(output, &sliceSource{lines: lines}, config.compare, unique)
 }
 
 // Функция, выводящая информацию о том, как использовать программу
 func printUsage() {
-	fmt.Println(`Usage: go run <filename>.go sort [options] <inputfile>
+	fmt.Fprintln(os.Stderr, `Usage: go run develop/dev03/task.go [options] [file...]
 Options:
-  -k <column>       Sort by column (default is the first column)
-  -n                Sort by numeric value
+  -k <key>          Sort key POS1[,POS2][OPTS]; POS is F[.C] (field, character),
+                    OPTS are b, g, h, M, n, R, r, V; may be repeated
+  -n                Sort by numeric value (sign, decimals, thousands separators)
   -r                Sort in reverse order
-  -u                Suppress duplicate lines
+  -u                Output only the first of lines with equal keys
   -M                Sort by month names
   -b                Ignore trailing spaces
-  -c                Check if data is sorted
-  -h                Sort by numeric value considering suffixes
+  -c                Check if data is sorted; report the first disorder and exit with status 1
+  -C                Like -c, but do not report the first disorder
+  -m                Merge already sorted files without sorting
+  -h                Sort by numeric value considering suffixes (2K, 1.5M, 1Gi)
+  -g                Sort by general floating-point value (1e3, inf, nan)
+  -S <size>         Memory buffer size (e.g. 100M); larger inputs are sorted externally
+  -T <dir>          Directory for temporary files of external sort
+  -t <sep>          Field separator character (e.g. ':' or '\t'); fields are blank-separated by default
+  -s                Stable sort: keep input order of lines with equal keys
+  -o <file>         Write result to file instead of standard output (may be one of the inputs)
+  -V                Natural sort of version numbers within text
+  -R                Shuffle lines, keeping lines with equal keys together
+  --seed <text>     Seed for -R; the same seed gives the same order
+  --random-source <file>
+                    File to read random bytes for -R from
+  --csv             Parse input as RFC 4180 CSV (sorted in memory); -k selects columns
+                    by number or, with --header, by name (-k name:OPTS); -t sets the delimiter
+  --header          With --csv, keep the first record as a header at the top
+  --parallel=<n>    Number of goroutines used to sort in memory (default: CPUs, at most 8)
+  -locale <lang>    Compare text using Unicode collation rules for the language (e.g. ru, en)
+
+With no file, or when file is -, standard input is read.
 
 Example:
-  go run develop/dev03/task.go sort -k 2 -n input.txt
-  go run develop/dev03/task.go sort -M input.txt`)
-}
-
-// Создание файла input.txt, если он не существует
-func ensureInputFileExists() {
-	const sampleData = `January 12
-April 45K
-August 7
-December 90
-February 1.5M
-`
-	filename := "input.txt"
-	if _, err := os.Stat(filename); os.IsNotExist(err) {
-		err := os.WriteFile(filename, []byte(sampleData), 0644)
-		if err != nil {
-			fmt.Println("Error creating file:", err)
-		} else {
-			fmt.Printf("File %s created with sample data.\n", filename)
+  go run develop/dev03/task.go -k 2 -n input.txt
+  go run develop/dev03/task.go -k1,1 -k2,2nr staff.txt
+  go run develop/dev03/task.go -t : -k3,3n /etc/passwd
+  ls -l | go run develop/dev03/task.go -k5,5n
+  go run develop/dev03/task.go -M -o input.txt input.txt
+  go run develop/dev03/task.go -locale ru names.txt
+  go run develop/dev03/task.go -S 64M -T /var/tmp big.log
+  go run develop/dev03/task.go -m -k1,1 shard1.txt shard2.txt shard3.txt
+  go run develop/dev03/task.go --csv --header -k department -k salary:nr export.csv`)
+}
+
+// inputSource — строки входных файлов по очереди; "-" — стандартный ввод
+type inputSource struct {
+	names  []string
+	file   *os.File
+	reader *bufio.Reader
+}
+
+func (s *inputSource) next() (string, error) {
+	for {
+		if s.reader == nil {
+			if len(s.names) == 0 {
+				return "", io.EOF
+			}
+			name := s.names[0]
+			s.names = s.names[1:]
+			if name == "-" {
+				s.reader = bufio.NewReader(os.Stdin)
+			} else {
+				file, err := os.Open(name)
+				if err != nil {
+					return "", err
+				}
+				s.file, s.reader = file, bufio.NewReader(file)
+			}
 		}
+
+		line, err := readLine(s.reader)
+		if err != io.EOF {
+			return line, err
+		}
+		s.close()
 	}
 }
 
-// Чтение строк из файла
-func readLines(filename string) ([]string, error) {
-	file, err := os.Open(filename)
-	if err != nil {
-		return nil, err
+// Закрытие текущего файла
+func (s *inputSource) close() {
+	if s.file != nil {
+		s.file.Close()
 	}
-	defer file.Close()
+	s.file, s.reader = nil, nil
+}
 
+// Чтение всех строк источника
+func collectLines(source lineSource) ([]string, error) {
 	var lines []string
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		lines = append(lines, scanner.Text())
+	for {
+		line, err := source.next()
+		if err == io.EOF {
+			return lines, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
 	}
+}
+
+// outputFile — файл результата (флаг -o). Файл создаётся при первой записи
+// или при закрытии: к этому моменту вход прочитан целиком, поэтому
+// результат можно записать в один из входных файлов.
+type outputFile struct {
+	name string
+	file *os.File
+}
 
-	if err := scanner.Err(); err != nil {
-		return nil, err
+func (o *outputFile) Write(p []byte) (int, error) {
+	if err := o.open(); err != nil {
+		return 0, err
 	}
-	return lines, nil
+	return o.file.Write(p)
 }
 
-// Запись строк в файл
-func writeLines(filename string, lines []string) error {
-	file, err := os.Create(filename)
+func (o *outputFile) Close() error {
+	if err := o.open(); err != nil {
+		return err
+	}
+	return o.file.Close()
+}
+
+func (o *outputFile) open() error {
+	if o.file != nil {
+		return nil
+	}
+	file, err := os.Create(o.name)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	o.file = file
+	return nil
+}
 
-	writer := bufio.NewWriter(file)
-	for _, line := range lines {
-		writer.WriteString(line + "\n")
+// ================== Ключи сортировки ==================
+
+// sortOptions — модификаторы сравнения ключа
+type sortOptions struct {
+	Numeric         bool // n — по числовому значению
+	Human           bool // h — по числовому значению с суффиксами
+	General         bool // g — по значению числа с плавающей точкой
+	Month           bool // M — по названию месяца
+	Reverse         bool // r — в обратном порядке
+	IgnoreBlanks    bool // b у начала ключа — без начальных пробелов начального поля
+	IgnoreEndBlanks bool // b у конца ключа — без начальных пробелов конечного поля
+	Version         bool // V — с учётом номеров версий
+	Random          bool // R — в случайном порядке групп равных ключей
+}
+
+// keySpec — ключ сортировки в формате GNU sort: F[.C][OPTS][,F[.C][OPTS]].
+// Поля и символы нумеруются с единицы; EndField == 0 означает конец строки,
+// EndChar == 0 — конец поля. Ключ с StartField == 0 — строка целиком.
+// Для CSV с заголовком колонку можно задать именем: NAME[:OPTS].
+type keySpec struct {
+	Name       string // имя колонки CSV; номер колонки определяется по заголовку
+	StartField int
+	StartChar  int
+	EndField   int
+	EndChar    int
+	Options    sortOptions
+}
+
+var keySpecRe = regexp.MustCompile(`^(\d+)(?:\.(\d+))?([bghMnRrV]*)(?:,(\d+)(?:\.(\d+))?([bghMnRrV]*))?$`)
+
+// Разбор описания ключа -k
+func parseKeySpec(value string) (keySpec, error) {
+	m := keySpecRe.FindStringSubmatch(value)
+	if m == nil {
+		return keySpec{}, fmt.Errorf("invalid key %q", value)
 	}
-	return writer.Flush()
+
+	key := keySpec{StartChar: 1}
+	key.StartField, _ = strconv.Atoi(m[1])
+	if m[2] != "" {
+		key.StartChar, _ = strconv.Atoi(m[2])
+	}
+	if m[4] != "" {
+		key.EndField, _ = strconv.Atoi(m[4])
+	}
+	if m[5] != "" {
+		key.EndChar, _ = strconv.Atoi(m[5])
+	}
+	if key.StartField == 0 || key.StartChar == 0 || (m[4] != "" && key.EndField == 0) {
+		return keySpec{}, fmt.Errorf("invalid key %q: field and character numbers start at 1", value)
+	}
+
+	// b относится только к своей позиции, остальные модификаторы — ко всему ключу
+	key.Options = parseKeyOptions(strings.ReplaceAll(m[3]+m[6], "b", ""))
+	key.Options.IgnoreBlanks = strings.Contains(m[3], "b")
+	key.Options.IgnoreEndBlanks = strings.Contains(m[6], "b")
+	return key, nil
 }
 
-// Сравнение строк с учетом параметров
-func compareLines(line1, line2 string, column int, numeric bool, ignoreSpaces bool, humanReadable bool) int {
-	if ignoreSpaces {
-		line1 = strings.TrimSpace(line1)
-		line2 = strings.TrimSpace(line2)
+var columnKeyRe = regexp.MustCompile(`^([^0-9:,][^:,]*)(?::([bghMnRrV]*))?$`)
+
+// Разбор ключа-колонки CSV по имени: NAME[:OPTS]
+func parseColumnKey(value string) (keySpec, bool) {
+	m := columnKeyRe.FindStringSubmatch(value)
+	if m == nil {
+		return keySpec{}, false
 	}
+	return keySpec{Name: m[1], StartChar: 1, Options: parseKeyOptions(m[2])}, true
+}
 
-	fields1 := strings.Fields(line1)
-	fields2 := strings.Fields(line2)
+// Разбор модификаторов ключа
+func parseKeyOptions(letters string) sortOptions {
+	var opts sortOptions
+	for _, opt := range letters {
+		switch opt {
+		case 'b':
+			opts.IgnoreBlanks = true
+			opts.IgnoreEndBlanks = true
+		case 'g':
+			opts.General = true
+		case 'h':
+			opts.Human = true
+		case 'M':
+			opts.Month = true
+		case 'n':
+			opts.Numeric = true
+		case 'r':
+			opts.Reverse = true
+		case 'V':
+			opts.Version = true
+		case 'R':
+			opts.Random = true
+		}
+	}
+	return opts
+}
 
-	if column-1 >= len(fields1) || column-1 >= len(fields2) {
-		return 0
+// Строковое представление ключа в формате -k
+func (k keySpec) String() string {
+	var b strings.Builder
+	if k.Name != "" {
+		b.WriteString(k.Name)
+		if k.Options != (sortOptions{}) {
+			b.WriteString(":")
+		}
+		if k.Options.IgnoreBlanks || k.Options.IgnoreEndBlanks {
+			b.WriteString("b")
+		}
+	} else {
+		fmt.Fprintf(&b, "%d", k.StartField)
+		if k.StartChar > 1 {
+			fmt.Fprintf(&b, ".%d", k.StartChar)
+		}
+		if k.Options.IgnoreBlanks {
+			b.WriteString("b")
+		}
+		if k.EndField > 0 {
+			fmt.Fprintf(&b, ",%d", k.EndField)
+			if k.EndChar > 0 {
+				fmt.Fprintf(&b, ".%d", k.EndChar)
+			}
+			if k.Options.IgnoreEndBlanks {
+				b.WriteString("b")
+			}
+		}
+	}
+	for _, opt := range []struct {
+		set  bool
+		name string
+	}{
+		{k.Options.General, "g"},
+		{k.Options.Human, "h"},
+		{k.Options.Month, "M"},
+		{k.Options.Numeric, "n"},
+		{k.Options.Reverse, "r"},
+		{k.Options.Version, "V"},
+		{k.Options.Random, "R"},
+	} {
+		if opt.set {
+			b.WriteString(opt.name)
+		}
+	}
+	return b.String()
+}
+
+// Извлечение ключа из строки. При модификаторе b начальные пробелы поля
+// не учитываются при отсчёте символов: у начала ключа — начального поля,
+// у конца — конечного.
+func (k keySpec) extract(line string, separator rune, opts sortOptions) string {
+	key := line
+	if k.StartField > 0 {
+		bounds := fieldBounds(line, separator)
+		if k.StartField > len(bounds) {
+			return ""
+		}
+		start := bounds[k.StartField-1][0]
+		if opts.IgnoreBlanks {
+			start = skipBlanks(line, start)
+		}
+		start = advanceRunes(line, start, k.StartChar-1)
+
+		end := len(line)
+		if k.EndField > 0 && k.EndField <= len(bounds) {
+			field := bounds[k.EndField-1]
+			if k.EndChar == 0 {
+				end = field[1]
+			} else {
+				end = field[0]
+				if opts.IgnoreEndBlanks {
+					end = skipBlanks(line, end)
+				}
+				end = advanceRunes(line, end, k.EndChar)
+			}
+		}
+		if end <= start {
+			return ""
+		}
+		key = line[start:end]
+	} else if opts.IgnoreBlanks {
+		// Строка целиком: как в GNU sort, пропускаются только начальные пробелы
+		key = line[skipBlanks(line, 0):]
 	}
+	return key
+}
 
-	if humanReadable {
-		num1 := parseHumanReadable(fields1[column-1])
-		num2 := parseHumanReadable(fields2[column-1])
-		return num1 - num2
+// Границы полей строки. С разделителем поля разделены каждым его вхождением
+// (возможны пустые поля). Без разделителя, как в GNU sort, поле — это
+// пробелы и табуляции вместе со следующими за ними непробельными символами.
+func fieldBounds(line string, separator rune) [][2]int {
+	var bounds [][2]int
+	if separator != 0 {
+		start := 0
+		for {
+			i := strings.IndexRune(line[start:], separator)
+			if i < 0 {
+				return append(bounds, [2]int{start, len(line)})
+			}
+			bounds = append(bounds, [2]int{start, start + i})
+			start += i + utf8.RuneLen(separator)
+		}
 	}
 
-	if numeric {
-		num1, _ := strconv.Atoi(fields1[column-1])
-		num2, _ := strconv.Atoi(fields2[column-1])
-		return num1 - num2
+	for pos := 0; pos < len(line); {
+		start := pos
+		pos = skipBlanks(line, pos)
+		for pos < len(line) && !isBlank(line[pos]) {
+			pos++
+		}
+		bounds = append(bounds, [2]int{start, pos})
 	}
+	return bounds
+}
 
-	return strings.Compare(fields1[column-1], fields2[column-1])
+// Пропуск пробелов и табуляций начиная с позиции pos
+func skipBlanks(s string, pos int) int {
+	for pos < len(s) && isBlank(s[pos]) {
+		pos++
+	}
+	return pos
 }
 
-// Обработка числовых значений с суффиксами
-func parseHumanReadable(value string) int {
-	re := regexp.MustCompile(`^([0-9]+)([KkMmGg]?)$`)
-	matches := re.FindStringSubmatch(value)
-	if len(matches) == 3 {
-		num, _ := strconv.Atoi(matches[1])
-		suffix := matches[2]
-		switch suffix {
-		case "K", "k":
-			return num * 1000
-		case "M", "m":
-			return num * 1000000
-		case "G", "g":
-			return num * 1000000000
-		default:
-			return num
+func isBlank(c byte) bool {
+	return c == ' ' || c == '\t'
+}
+
+// Разбор разделителя полей (флаг -t): один символ; табуляцию можно
+// передать как \t
+func parseSeparator(value string) (rune, error) {
+	switch value {
+	case "":
+		return 0, nil
+	case `\t`:
+		return '\t', nil
+	}
+	if utf8.RuneCountInString(value) != 1 {
+		return 0, fmt.Errorf("separator must be a single character: %q", value)
+	}
+	r, _ := utf8.DecodeRuneInString(value)
+	return r, nil
+}
+
+// Смещение на n символов вперёд от позиции pos, не дальше конца строки
+func advanceRunes(s string, pos, n int) int {
+	for ; n > 0 && pos < len(s); n-- {
+		_, size := utf8.DecodeRuneInString(s[pos:])
+		pos += size
+	}
+	return pos
+}
+
+// keyList — значение повторяемого флага -k
+type keyList []keySpec
+
+func (l *keyList) String() string {
+	if l == nil {
+		return ""
+	}
+	specs := make([]string, len(*l))
+	for i, key := range *l {
+		specs[i] = key.String()
+	}
+	return strings.Join(specs, " ")
+}
+
+func (l *keyList) Set(value string) error {
+	key, err := parseKeySpec(value)
+	if err != nil {
+		column, ok := parseColumnKey(value)
+		if !ok {
+			return err
 		}
+		key = column
 	}
-	return 0
+	*l = append(*l, key)
+	return nil
 }
 
-// Удаление дубликатов
-func removeDuplicates(lines []string) []string {
-	keys := make(map[string]bool)
-	var result []string
-	for _, line := range lines {
-		if _, value := keys[line]; !value {
-			keys[line] = true
-			result = append(result, line)
+// Флаги, значение которых в GNU sort может быть записано слитно (-k2,2n, -S64M, -t:)
+const attachedValueFlags = "kSTt"
+
+// Разделение слитно записанных значений флагов: пакет flag понимает
+// только -k 2,2n или -k=2,2n
+func splitAttachedValues(args []string) []string {
+	result := make([]string, 0, len(args))
+	for i, arg := range args {
+		if arg == "--" {
+			return append(result, args[i:]...)
+		}
+		// -t= — разделитель '=', а не пустое значение в форме -t=VALUE
+		if len(arg) > 2 && arg[0] == '-' && strings.IndexByte(attachedValueFlags, arg[1]) >= 0 &&
+			(arg[2] != '=' || arg == "-t=") {
+			result = append(result, arg[:2], arg[2:])
+			continue
 		}
+		result = append(result, arg)
 	}
 	return result
 }
 
-// Проверка отсортированных данных
-func isSorted(lines []string, column int, numeric bool, ignoreSpaces bool, humanReadable bool, monthSort bool) bool {
-	// Проверка на пустой ввод
-	if len(lines) == 0 {
-		return true
+// sortConfig — ключи сортировки и глобальные модификаторы. Ключ без
+// собственных модификаторов наследует глобальные; без ключей сравнивается
+// строка целиком.
+type sortConfig struct {
+	Keys      []keySpec
+	Separator rune       // 0 — поля разделены пробелами
+	Stable    bool       // не сравнивать строки целиком при равных ключах
+	Collation *collation // nil — побайтовое сравнение
+	Salt      []byte     // соль хеша ключей для -R
+	Global    sortOptions
+}
+
+// Ключи сортировки; без ключей — строка целиком с глобальными модификаторами
+func (c sortConfig) keys() []keySpec {
+	if len(c.Keys) == 0 {
+		return []keySpec{{Options: c.Global}}
 	}
+	return c.Keys
+}
 
-	// Переменные для отслеживания, отсортированы ли данные по месяцам и обычной сортировке
-	isMonthSorted := true
-	isColumnSorted := true
+// Модификаторы ключа: собственные или, если их нет, глобальные
+func (c sortConfig) keyOptions(key keySpec) sortOptions {
+	if key.Options == (sortOptions{}) {
+		return c.Global
+	}
+	return key.Options
+}
 
-	for i := 1; i < len(lines); i++ {
-		// Проверка сортировки по месяцам
-		if monthSort {
-			if monthIndex(lines[i-1]) == 0 || monthIndex(lines[i]) == 0 {
-				// Пропускаем строки с нераспознанными месяцами
-				continue
+// Сравнение строк по ключам. Строки с равными ключами сравниваются целиком,
+// чтобы одинаковые строки оказывались рядом и порядок не зависел от того,
+// в какую серию попала строка.
+func (c sortConfig) compare(a, b string) int {
+	for _, key := range c.keys() {
+		opts := c.keyOptions(key)
+		r := c.compareKeys(key.extract(a, c.Separator, opts), key.extract(b, c.Separator, opts), opts)
+		if opts.Reverse {
+			r = -r
+		}
+		if r != 0 {
+			return r
+		}
+	}
+	if c.Stable {
+		return 0
+	}
+
+	r := c.compareText(a, b)
+	if r == 0 {
+		r = strings.Compare(a, b)
+	}
+	if c.Global.Reverse {
+		return -r
+	}
+	return r
+}
+
+// Сравнение значений ключей с учётом модификаторов (кроме обратного порядка)
+func (c sortConfig) compareKeys(a, b string, opts sortOptions) int {
+	switch {
+	case opts.Random:
+		r := cmp.Compare(c.randomHash(a), c.randomHash(b))
+		if r == 0 {
+			r = strings.Compare(a, b)
+		}
+		return r
+	case opts.Month:
+		return cmp.Compare(monthIndex(a), monthIndex(b))
+	case opts.Human:
+		return cmp.Compare(parseHumanReadable(a), parseHumanReadable(b))
+	case opts.General:
+		return compareGeneral(a, b)
+	case opts.Numeric:
+		num1, _ := parseDecimal(a)
+		num2, _ := parseDecimal(b)
+		return num1.compare(num2)
+	case opts.Version:
+		return compareVersions(a, b)
+	}
+	return c.compareText(a, b)
+}
+
+// Сравнение текста по правилам локали или побайтово
+func (c sortConfig) compareText(a, b string) int {
+	if c.Collation != nil {
+		return c.Collation.collator.CompareString(a, b)
+	}
+	return strings.Compare(a, b)
+}
+
+// collation — правила сравнения текста для языка (флаг -locale).
+// Collator хранит состояние между вызовами и не безопасен для
+// одновременного использования, поэтому горутины работают с копиями.
+type collation struct {
+	tag      language.Tag
+	collator *collate.Collator
+}
+
+// Создание правил сравнения для языка; пустая строка — побайтовое сравнение
+func newCollation(locale string) (*collation, error) {
+	if locale == "" {
+		return nil, nil
+	}
+	tag, err := language.Parse(locale)
+	if err != nil {
+		return nil, fmt.Errorf("invalid locale %q: %w", locale, err)
+	}
+	return &collation{tag: tag, collator: collate.New(tag)}, nil
+}
+
+// Копия правил для использования в отдельной горутине
+func (c *collation) clone() *collation {
+	return &collation{tag: c.tag, collator: collate.New(c.tag)}
+}
+
+// Ключ сопоставления: побайтовое сравнение ключей совпадает со сравнением строк
+func (c *collation) key(buf *collate.Buffer, s string) string {
+	key := string(c.collator.KeyFromString(buf, s))
+	buf.Reset()
+	return key
+}
+
+// Хеш ключа с солью для -R: равные ключи получают равные хеши и
+// оказываются рядом, а порядок групп определяется солью
+func (c sortConfig) randomHash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write(c.Salt)
+	io.WriteString(h, key)
+
+	// Перемешивание битов (финализатор SplitMix64): у FNV близкие ключи
+	// дают близкие значения старших битов
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// Соль для -R: из --seed, из файла --random-source или случайная
+func randomSalt(seed, source string) ([]byte, error) {
+	if seed != "" {
+		return []byte(seed), nil
+	}
+
+	salt := make([]byte, 16)
+	input := rand.Reader
+	if source != "" {
+		file, err := os.Open(source)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		input = file
+	}
+	n, err := io.ReadFull(input, salt)
+	if n == 0 || (err != nil && err != io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("cannot read random source: %w", err)
+	}
+	return salt[:n], nil
+}
+
+// Сравнение строк с номерами версий: последовательности цифр сравниваются
+// как числа, остальные части — побайтово
+func compareVersions(a, b string) int {
+	for a != "" && b != "" {
+		partA, restA := versionPart(a)
+		partB, restB := versionPart(b)
+		digitsA, digitsB := isDigit(partA[0]), isDigit(partB[0])
+
+		var r int
+		switch {
+		case digitsA && digitsB:
+			numA := strings.TrimLeft(partA, "0")
+			numB := strings.TrimLeft(partB, "0")
+			r = cmp.Compare(len(numA), len(numB))
+			if r == 0 {
+				r = strings.Compare(numA, numB)
 			}
-			if monthIndex(lines[i-1]) > monthIndex(lines[i]) {
-				isMonthSorted = false
+		case digitsA != digitsB:
+			// Число идёт раньше текста
+			if digitsA {
+				r = -1
+			} else {
+				r = 1
 			}
+		default:
+			r = strings.Compare(partA, partB)
 		}
+		if r != 0 {
+			return r
+		}
+		a, b = restA, restB
+	}
+	return cmp.Compare(len(a), len(b))
+}
+
+// Первая часть строки: последовательность цифр или последовательность остальных символов
+func versionPart(s string) (string, string) {
+	digits := isDigit(s[0])
+	i := 1
+	for i < len(s) && isDigit(s[i]) == digits {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// Сортировка строк в памяти; строки с равными ключами сохраняют исходный порядок
+func sortLines(lines []string, cmp func(a, b string) int) {
+	sort.SliceStable(lines, func(i, j int) bool {
+		return cmp(lines[i], lines[j]) < 0
+	})
+}
+
+// ================== Числа ==================
+
+// decimal — число в формате -n: знак, целая часть без ведущих нулей
+// и дробная часть без хвостовых нулей. Сравнение выполняется по цифрам,
+// поэтому точность не ограничена.
+type decimal struct {
+	Negative bool
+	Integer  string
+	Fraction string
+}
 
-		// Проверка сортировки по указанному столбцу
-		compResult := compareLines(lines[i-1], lines[i], column, numeric, ignoreSpaces, humanReadable)
-		if compResult > 0 {
-			isColumnSorted = false
+// Разбор числа в начале строки: начальные пробелы, необязательный знак,
+// цифры с разделителями тысяч (запятая между цифрами) и дробная часть
+// после точки. Строка без числа считается нулём. Вторым значением
+// возвращается остаток строки после числа.
+func parseDecimal(s string) (decimal, string) {
+	var d decimal
+	i := skipBlanks(s, 0)
+	if i < len(s) && (s[i] == '-' || s[i] == '+') {
+		d.Negative = s[i] == '-'
+		i++
+	}
+
+	var integer strings.Builder
+	for i < len(s) {
+		if isDigit(s[i]) {
+			integer.WriteByte(s[i])
+		} else if s[i] != ',' || integer.Len() == 0 || i+1 == len(s) || !isDigit(s[i+1]) {
+			break
 		}
+		i++
+	}
+
+	hasFraction := false
+	if i < len(s) && s[i] == '.' {
+		j := i + 1
+		for j < len(s) && isDigit(s[j]) {
+			j++
+		}
+		if integer.Len() > 0 || j > i+1 {
+			d.Fraction = s[i+1 : j]
+			hasFraction = true
+			i = j
+		}
+	}
+	if integer.Len() == 0 && !hasFraction {
+		return decimal{}, s
 	}
 
-	// Если данные отсортированы хотя бы по одному критерию, возвращаем true
-	return isMonthSorted || isColumnSorted
+	d.Integer = strings.TrimLeft(integer.String(), "0")
+	d.Fraction = strings.TrimRight(d.Fraction, "0")
+	if d.Integer == "" && d.Fraction == "" {
+		// -0 равен 0
+		d.Negative = false
+	}
+	return d, s[i:]
+}
+
+// Сравнение чисел
+func (d decimal) compare(other decimal) int {
+	if d.Negative != other.Negative {
+		if d.Negative {
+			return -1
+		}
+		return 1
+	}
+
+	r := cmp.Compare(len(d.Integer), len(other.Integer))
+	if r == 0 {
+		r = strings.Compare(d.Integer, other.Integer)
+	}
+	if r == 0 {
+		r = strings.Compare(d.Fraction, other.Fraction)
+	}
+	if d.Negative {
+		return -r
+	}
+	return r
+}
+
+// Значение числа с плавающей точкой
+func (d decimal) float() float64 {
+	text := "0" + d.Integer + "." + d.Fraction + "0"
+	value, _ := strconv.ParseFloat(text, 64)
+	if d.Negative {
+		return -value
+	}
+	return value
+}
+
+// Суффиксы -h в порядке возрастания степени
+const humanSuffixes = "KMGTPEZY"
+
+// Обработка числовых значений с суффиксами: K, M, G, T, P, E (и Z, Y) —
+// степени 1000 (SI), с буквой i (Ki, Mi, ...) — степени 1024 (IEC)
+func parseHumanReadable(value string) float64 {
+	d, rest := parseDecimal(value)
+	number := d.float()
+	if rest == "" {
+		return number
+	}
+
+	power := strings.IndexByte(humanSuffixes, strings.ToUpper(rest[:1])[0])
+	if power < 0 {
+		return number
+	}
+	base := 1000.0
+	if len(rest) > 1 && rest[1] == 'i' {
+		base = 1024
+	}
+	return number * math.Pow(base, float64(power+1))
+}
+
+var generalNumberRe = regexp.MustCompile(`^[ \t]*[-+]?(?i:inf(?:inity)?|nan|(?:[0-9]+\.?[0-9]*|\.[0-9]+)(?:e[-+]?[0-9]+)?)`)
+
+// Сравнение чисел с плавающей точкой (-g): строки без числа идут первыми,
+// затем nan, затем числа по возрастанию, включая -inf и inf
+func compareGeneral(a, b string) int {
+	rankA, valueA := parseGeneral(a)
+	rankB, valueB := parseGeneral(b)
+	if rankA != rankB || rankA < 2 {
+		return cmp.Compare(rankA, rankB)
+	}
+	return cmp.Compare(valueA, valueB)
+}
+
+// Разбор числа в начале строки для -g. Ранг: 0 — не число, 1 — nan, 2 — число
+func parseGeneral(s string) (int, float64) {
+	match := generalNumberRe.FindString(s)
+	if match == "" {
+		return 0, 0
+	}
+	value, err := strconv.ParseFloat(strings.TrimLeft(match, " \t"), 64)
+	if err != nil && !errors.Is(err, strconv.ErrRange) {
+		return 0, 0
+	}
+	if math.IsNaN(value) {
+		return 1, 0
+	}
+	return 2, value
+}
+
+// Поиск первой строки, нарушающей порядок: возвращает её номер (с единицы)
+// и текст или 0, если данные отсортированы. При unique нарушением считаются
+// и соседние строки с равными ключами.
+func findDisorder(source lineSource, config sortConfig, unique bool) (int, string, error) {
+	if unique {
+		// Строки сравниваются только по ключам
+		config.Stable = true
+	}
+
+	prev, err := source.next()
+	if err == io.EOF {
+		return 0, "", nil
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	for number := 2; ; number++ {
+		line, err := source.next()
+		if err == io.EOF {
+			return 0, "", nil
+		}
+		if err != nil {
+			return 0, "", err
+		}
+		r := config.compare(prev, line)
+		if r > 0 || (unique && r == 0) {
+			return number, line, nil
+		}
+		prev = line
+	}
 }
 
 // Индекс месяца
@@ -328,3 +1116,662 @@ func monthIndex(line string) int {
 	}
 	return 0
 }
+
+// ================== CSV ==================
+
+// Разделитель, через который склеиваются поля записи CSV: ключи извлекаются
+// из склеенной записи так же, как из обычной строки с разделителем -t
+const csvFieldSeparator = '\x1f'
+
+// Сортировка CSV-файла ("-" — стандартный ввод)
+func sortCSVFile(name string, output io.Writer, config sortConfig, header, unique bool, workers int) error {
+	input := os.Stdin
+	if name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+	return sortCSV(input, output, config, header, unique, workers)
+}
+
+// Сортировка записей CSV (RFC 4180): поля в кавычках могут содержать
+// разделители и переводы строк. Разделитель полей задаёт config.Separator
+// (по умолчанию запятая). Записи выводятся в исходном виде, заголовок
+// (header) остаётся первым.
+func sortCSV(input io.Reader, output io.Writer, config sortConfig, header, unique bool, workers int) error {
+	data, err := io.ReadAll(input)
+	if err != nil {
+		return err
+	}
+	reader := csv.NewReader(bytes.NewReader(data))
+	if config.Separator != 0 {
+		reader.Comma = config.Separator
+	}
+	reader.FieldsPerRecord = -1
+
+	var headerText string
+	var columns []string
+	var lines []string
+	// Исходный текст записей; одинаковые склеенные записи выводятся в порядке ввода
+	texts := make(map[string][]string)
+	var offset int64
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		// Пустые строки перед записью пропускаются так же, как их пропускает csv.Reader
+		text := strings.TrimLeft(string(data[offset:reader.InputOffset()]), "\r\n")
+		offset = reader.InputOffset()
+		if !strings.HasSuffix(text, "\n") {
+			text += "\n"
+		}
+
+		if header && columns == nil {
+			headerText, columns = text, record
+			continue
+		}
+		line := strings.Join(record, string(csvFieldSeparator))
+		lines = append(lines, line)
+		texts[line] = append(texts[line], text)
+	}
+
+	config.Keys, err = resolveColumns(config.Keys, columns)
+	if err != nil {
+		return err
+	}
+	config.Separator = csvFieldSeparator
+	if unique {
+		// Из записей с равными ключами выводится первая
+		config.Stable = true
+	}
+	parallelSort(lines, config, workers)
+
+	writer := bufio.NewWriter(output)
+	writer.WriteString(headerText)
+	for i, line := range lines {
+		text := texts[line][0]
+		texts[line] = texts[line][1:]
+		if unique && i > 0 && config.compare(lines[i-1], line) == 0 {
+			continue
+		}
+		writer.WriteString(text)
+	}
+	return writer.Flush()
+}
+
+// Замена имён колонок в ключах на их номера по заголовку
+func resolveColumns(keys []keySpec, columns []string) ([]keySpec, error) {
+	resolved := make([]keySpec, len(keys))
+	for i, key := range keys {
+		if key.Name != "" {
+			index := slices.Index(columns, key.Name)
+			if index < 0 {
+				return nil, fmt.Errorf("unknown column %q", key.Name)
+			}
+			key.StartField, key.EndField = index+1, index+1
+		}
+		resolved[i] = key
+	}
+	return resolved, nil
+}
+
+// ================== Параллельная сортировка ==================
+
+// preparedLine — строка с заранее извлечёнными ключами: поля не разбираются
+// заново при каждом сравнении
+type preparedLine struct {
+	line    string
+	lineKey string // ключ сопоставления строки целиком (при -locale)
+	keys    []preparedKey
+}
+
+// preparedKey — значение ключа в виде, удобном для сравнения
+type preparedKey struct {
+	text   string  // текст ключа или ключ сопоставления (при -locale)
+	number decimal // -n
+	value  float64 // -h, -g
+	rank   int     // -g: 0 — не число, 1 — nan, 2 — число; -M: номер месяца
+	hash   uint64  // -R
+}
+
+// Извлечение ключей строки; coll — копия правил сравнения для горутины
+func (c sortConfig) prepare(line string, coll *collation, buf *collate.Buffer) preparedLine {
+	keys := c.keys()
+	p := preparedLine{line: line, keys: make([]preparedKey, len(keys))}
+	for i, key := range keys {
+		opts := c.keyOptions(key)
+		value := key.extract(line, c.Separator, opts)
+		k := &p.keys[i]
+		switch {
+		case opts.Random:
+			k.hash, k.text = c.randomHash(value), value
+		case opts.Month:
+			k.rank = monthIndex(value)
+		case opts.Human:
+			k.value = parseHumanReadable(value)
+		case opts.General:
+			k.rank, k.value = parseGeneral(value)
+		case opts.Numeric:
+			k.number, _ = parseDecimal(value)
+		case opts.Version || coll == nil:
+			k.text = value
+		default:
+			k.text = coll.key(buf, value)
+		}
+	}
+	if coll != nil && !c.Stable {
+		p.lineKey = coll.key(buf, line)
+	}
+	return p
+}
+
+// Сравнение строк с извлечёнными ключами; порядок совпадает с compare
+func (c sortConfig) comparePrepared(a, b *preparedLine) int {
+	for i, key := range c.keys() {
+		opts := c.keyOptions(key)
+		x, y := &a.keys[i], &b.keys[i]
+		var r int
+		switch {
+		case opts.Random:
+			r = cmp.Compare(x.hash, y.hash)
+			if r == 0 {
+				r = strings.Compare(x.text, y.text)
+			}
+		case opts.Month:
+			r = cmp.Compare(x.rank, y.rank)
+		case opts.Human:
+			r = cmp.Compare(x.value, y.value)
+		case opts.General:
+			r = cmp.Compare(x.rank, y.rank)
+			if r == 0 && x.rank == 2 {
+				r = cmp.Compare(x.value, y.value)
+			}
+		case opts.Numeric:
+			r = x.number.compare(y.number)
+		case opts.Version:
+			r = compareVersions(x.text, y.text)
+		default:
+			r = strings.Compare(x.text, y.text)
+		}
+		if opts.Reverse {
+			r = -r
+		}
+		if r != 0 {
+			return r
+		}
+	}
+	if c.Stable {
+		return 0
+	}
+
+	r := strings.Compare(a.lineKey, b.lineKey)
+	if r == 0 {
+		r = strings.Compare(a.line, b.line)
+	}
+	if c.Global.Reverse {
+		return -r
+	}
+	return r
+}
+
+// Параллельная сортировка в памяти: строки делятся на части по числу
+// горутин, каждая горутина извлекает ключи своей части и сортирует её,
+// затем части попарно сливаются. Строки с равными ключами сохраняют
+// исходный порядок.
+func parallelSort(lines []string, config sortConfig, workers int) {
+	if len(lines) == 0 {
+		return
+	}
+	workers = max(1, min(workers, len(lines)))
+	size := (len(lines) + workers - 1) / workers
+
+	var chunks [][]preparedLine
+	var wg sync.WaitGroup
+	for lo := 0; lo < len(lines); lo += size {
+		part := lines[lo:min(lo+size, len(lines))]
+		chunk := make([]preparedLine, len(part))
+		chunks = append(chunks, chunk)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var coll *collation
+			if config.Collation != nil {
+				coll = config.Collation.clone()
+			}
+			var buf collate.Buffer
+			for i, line := range part {
+				chunk[i] = config.prepare(line, coll, &buf)
+			}
+			sort.SliceStable(chunk, func(i, j int) bool {
+				return config.comparePrepared(&chunk[i], &chunk[j]) < 0
+			})
+		}()
+	}
+	wg.Wait()
+
+	// Попарное слияние частей; слияния одного уровня выполняются параллельно
+	for len(chunks) > 1 {
+		merged := make([][]preparedLine, (len(chunks)+1)/2)
+		for i := 0; i < len(chunks); i += 2 {
+			if i+1 == len(chunks) {
+				merged[i/2] = chunks[i]
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				merged[i/2] = mergePrepared(chunks[i], chunks[i+1], config)
+			}()
+		}
+		wg.Wait()
+		chunks = merged
+	}
+
+	for i := range chunks[0] {
+		lines[i] = chunks[0][i].line
+	}
+}
+
+// Слияние двух отсортированных частей; при равенстве раньше идёт строка из левой части
+func mergePrepared(left, right []preparedLine, config sortConfig) []preparedLine {
+	result := make([]preparedLine, 0, len(left)+len(right))
+	for len(left) > 0 && len(right) > 0 {
+		if config.comparePrepared(&right[0], &left[0]) < 0 {
+			result = append(result, right[0])
+			right = right[1:]
+		} else {
+			result = append(result, left[0])
+			left = left[1:]
+		}
+	}
+	result = append(result, left...)
+	return append(result, right...)
+}
+
+// ================== Внешняя сортировка ==================
+
+// Оценка накладных расходов памяти на одну строку (заголовок строки и элемент среза)
+const lineOverhead = 32
+
+// Максимальное число серий, сливаемых за один проход
+var mergeFanIn = 64
+
+// Разбор размера буфера в формате GNU sort: число с необязательным суффиксом
+// b (байты), K, M, G, T (степени 1024); без суффикса — килобайты
+func parseSize(value string) (int64, error) {
+	re := regexp.MustCompile(`^([0-9]+)([bKkMmGgTt]?)$`)
+	matches := re.FindStringSubmatch(value)
+	if matches == nil {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	num, err := strconv.ParseInt(matches[1], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	multiplier := int64(1024)
+	switch strings.ToUpper(matches[2]) {
+	case "B":
+		multiplier = 1
+	case "M":
+		multiplier = 1 << 20
+	case "G":
+		multiplier = 1 << 30
+	case "T":
+		multiplier = 1 << 40
+	}
+	return num * multiplier, nil
+}
+
+// Чтение одной строки без символа перевода строки; io.EOF, если строк больше нет
+func readLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err == io.EOF && line == "" {
+		return "", io.EOF
+	}
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	line = strings.TrimSuffix(line, "\n")
+	return strings.TrimSuffix(line, "\r"), nil
+}
+
+// Внешняя сортировка слиянием: строки накапливаются, пока не исчерпан бюджет
+// памяти, затем сортируются и сбрасываются во временный файл (серию).
+// Серии сливаются k-путевым слиянием через кучу. Если вход помещается
+// в бюджет, сортировка выполняется целиком в памяти.
+func externalSort(input lineSource, output io.Writer, budget int64, dir string, cmp func(a, b string) int, unique bool) error {
+	var runs []string
+	defer func() {
+		for _, run := range runs {
+			os.Remove(run)
+		}
+	}()
+
+	var chunk []string
+	var used int64
+	for {
+		line, err := input.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		chunk = append(chunk, line)
+		used += int64(len(line)) + lineOverhead
+		if used >= budget {
+			run, err := writeRun(chunk, dir, cmp)
+			if err != nil {
+				return err
+			}
+			runs = append(runs, run)
+			chunk, used = chunk[:0], 0
+		}
+	}
+
+	// Весь вход поместился в память
+	if len(runs) == 0 {
+		sortLines(chunk, cmp)
+		return writeSorted(output, &sliceSource{lines: chunk}, cmp, unique)
+	}
+
+	if len(chunk) > 0 {
+		run, err := writeRun(chunk, dir, cmp)
+		if err != nil {
+			return err
+		}
+		runs = append(runs, run)
+	}
+
+	return mergeFiles(runs, true, output, dir, cmp, unique)
+}
+
+// Слияние отсортированных файлов в output. Если файлов больше, чем можно
+// открыть одновременно, первые из них сливаются в промежуточную серию,
+// которая занимает их место в начале списка: так при равенстве строк
+// порядок входов сохраняется. Слитые промежуточные серии удаляются сразу,
+// исходные файлы — только если temporary.
+func mergeFiles(files []string, temporary bool, output io.Writer, dir string, cmp func(a, b string) int, unique bool) error {
+	runs := make(map[string]bool)
+	defer func() {
+		for run := range runs {
+			os.Remove(run)
+		}
+	}()
+	if temporary {
+		for _, file := range files {
+			runs[file] = true
+		}
+	}
+
+	for len(files) > mergeFanIn {
+		merged, err := mergeToRun(files[:mergeFanIn], dir, cmp)
+		if err != nil {
+			return err
+		}
+		for _, file := range files[:mergeFanIn] {
+			if runs[file] {
+				os.Remove(file)
+				delete(runs, file)
+			}
+		}
+		runs[merged] = true
+		files = append([]string{merged}, files[mergeFanIn:]...)
+	}
+
+	return mergeRuns(files, output, cmp, unique)
+}
+
+// Слияние уже отсортированных входов без сортировки (флаг -m). Стандартный
+// ввод и входы, совпадающие с файлом результата, сначала копируются во
+// временные файлы: результат пишется раньше, чем входы прочитаны целиком.
+func mergeInputs(names []string, output io.Writer, outputPath, dir string, config sortConfig, unique bool) error {
+	if unique {
+		// Из строк с равными ключами выводится первая
+		config.Stable = true
+	}
+
+	var files, copies []string
+	defer func() {
+		for _, file := range copies {
+			os.Remove(file)
+		}
+	}()
+	for _, name := range names {
+		if name == "-" || sameFile(name, outputPath) {
+			file, err := copyToTemp(name, dir)
+			if err != nil {
+				return err
+			}
+			copies = append(copies, file)
+			name = file
+		}
+		files = append(files, name)
+	}
+
+	return mergeFiles(files, false, output, dir, config.compare, unique)
+}
+
+// Указывают ли пути на один и тот же файл
+func sameFile(name, other string) bool {
+	if other == "" {
+		return false
+	}
+	info1, err := os.Stat(name)
+	if err != nil {
+		return false
+	}
+	info2, err := os.Stat(other)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(info1, info2)
+}
+
+// Копирование входа ("-" — стандартный ввод) во временный файл
+func copyToTemp(name, dir string) (string, error) {
+	input := os.Stdin
+	if name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			return "", err
+		}
+		defer file.Close()
+		input = file
+	}
+
+	file, err := os.CreateTemp(dir, "sort-input-*")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, input); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+// Сортировка серии и запись её во временный файл
+func writeRun(lines []string, dir string, cmp func(a, b string) int) (string, error) {
+	sortLines(lines, cmp)
+
+	file, err := os.CreateTemp(dir, "sort-run-*")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if err := writeSorted(file, &sliceSource{lines: lines}, cmp, false); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+// Слияние нескольких серий в новую серию
+func mergeToRun(runs []string, dir string, cmp func(a, b string) int) (string, error) {
+	file, err := os.CreateTemp(dir, "sort-run-*")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if err := mergeRuns(runs, file, cmp, false); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+// Слияние отсортированных серий из файлов в output
+func mergeRuns(runs []string, output io.Writer, cmp func(a, b string) int, unique bool) error {
+	var sources []lineSource
+	for _, run := range runs {
+		file, err := os.Open(run)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		sources = append(sources, &readerSource{reader: bufio.NewReader(file)})
+	}
+
+	merged, err := newMergeSource(sources, cmp)
+	if err != nil {
+		return err
+	}
+	return writeSorted(output, merged, cmp, unique)
+}
+
+// Запись строк из источника; при unique пропускаются строки, равные
+// предыдущей выведенной с точки зрения cmp
+func writeSorted(output io.Writer, source lineSource, cmp func(a, b string) int, unique bool) error {
+	writer := bufio.NewWriter(output)
+	var prev string
+	first := true
+	for {
+		line, err := source.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if unique && !first && cmp(prev, line) == 0 {
+			continue
+		}
+		writer.WriteString(line + "\n")
+		prev, first = line, false
+	}
+	return writer.Flush()
+}
+
+// lineSource — последовательный источник строк
+type lineSource interface {
+	next() (string, error)
+}
+
+// sliceSource — строки из среза в памяти
+type sliceSource struct {
+	lines []string
+	pos   int
+}
+
+func (s *sliceSource) next() (string, error) {
+	if s.pos >= len(s.lines) {
+		return "", io.EOF
+	}
+	s.pos++
+	return s.lines[s.pos-1], nil
+}
+
+// readerSource — строки из буферизованного потока (файла серии)
+type readerSource struct {
+	reader *bufio.Reader
+}
+
+func (s *readerSource) next() (string, error) {
+	return readLine(s.reader)
+}
+
+// mergeItem — текущая строка одного из сливаемых источников
+type mergeItem struct {
+	line   string
+	source int
+}
+
+// mergeHeap — куча текущих строк источников; при равенстве строк раньше идёт
+// источник с меньшим номером, чтобы слияние было устойчивым
+type mergeHeap struct {
+	items []mergeItem
+	cmp   func(a, b string) int
+}
+
+func (h *mergeHeap) Len() int { return len(h.items) }
+func (h *mergeHeap) Less(i, j int) bool {
+	if c := h.cmp(h.items[i].line, h.items[j].line); c != 0 {
+		return c < 0
+	}
+	return h.items[i].source < h.items[j].source
+}
+func (h *mergeHeap) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *mergeHeap) Push(x interface{}) { h.items = append(h.items, x.(mergeItem)) }
+func (h *mergeHeap) Pop() interface{} {
+	item := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return item
+}
+
+// mergeSource — k-путевое слияние отсортированных источников
+type mergeSource struct {
+	sources []lineSource
+	heap    *mergeHeap
+}
+
+// Создание источника слияния; из каждого источника читается первая строка
+func newMergeSource(sources []lineSource, cmp func(a, b string) int) (*mergeSource, error) {
+	m := &mergeSource{sources: sources, heap: &mergeHeap{cmp: cmp}}
+	for i, source := range sources {
+		line, err := source.next()
+		if err == io.EOF {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		m.heap.items = append(m.heap.items, mergeItem{line: line, source: i})
+	}
+	heap.Init(m.heap)
+	return m, nil
+}
+
+func (m *mergeSource) next() (string, error) {
+	if m.heap.Len() == 0 {
+		return "", io.EOF
+	}
+	top := m.heap.items[0]
+
+	line, err := m.sources[top.source].next()
+	switch {
+	case err == io.EOF:
+		heap.Pop(m.heap)
+	case err != nil:
+		return "", err
+	default:
+		m.heap.items[0].line = line
+		heap.Fix(m.heap, 0)
+	}
+	return top.line, nil
+}
@@ -1,34 +1,16 @@
 package main
 
 import (
+	"bufio"
+	"fmt"
+	"os"
 	"reflect"
+	"slices"
 	"sort"
+	"strings"
 	"testing"
 )
 
-// Тест для проверки функции удаления дубликатов
-func TestRemoveDuplicates(t *testing.T) {
-	lines := []string{
-		"January 12",
-		"April 45K",
-		"August 7",
-		"January 12",
-		"February 1.5M",
-	}
-
-	expected := []string{
-		"January 12",
-		"April 45K",
-		"August 7",
-		"February 1.5M",
-	}
-
-	result := removeDuplicates(lines)
-	if !reflect.DeepEqual(result, expected) {
-		t.Errorf("removeDuplicates() = %v, expected %v", result, expected)
-	}
-}
-
 // Тест для функции monthIndex
 func TestMonthIndex(t *testing.T) {
 	tests := []struct {
@@ -50,21 +32,23 @@ func TestMonthIndex(t *testing.T) {
 	}
 }
 
-// Тест для функции compareLines
+// Тест сравнения строк по одной колонке
 func TestCompareLines(t *testing.T) {
 	line1 := "August 7"
 	line2 := "December 90"
 
 	// Сравнение без числовой и других сортировок
-	if compareLines(line1, line2, 1, false, false, false) >= 0 {
-		t.Errorf("compareLines() failed for normal comparison between %s and %s", line1, line2)
+	byText := sortConfig{Keys: []keySpec{{StartField: 1, StartChar: 1, EndField: 1}}}
+	if byText.compare(line1, line2) >= 0 {
+		t.Errorf("compare() failed for normal comparison between %s and %s", line1, line2)
 	}
 
 	// Сравнение по числовому значению
 	line1Numeric := "100"
-	line2Numeric := "200"
-	if compareLines(line1Numeric, line2Numeric, 1, true, false, false) >= 0 {
-		t.Errorf("compareLines() failed for numeric comparison between %s and %s", line1Numeric, line2Numeric)
+	line2Numeric := "20"
+	byNumber := sortConfig{Keys: []keySpec{{StartField: 1, StartChar: 1, EndField: 1, Options: sortOptions{Numeric: true}}}}
+	if byNumber.compare(line1Numeric, line2Numeric) <= 0 {
+		t.Errorf("compare() failed for numeric comparison between %s and %s", line1Numeric, line2Numeric)
 	}
 }
 
@@ -93,8 +77,16 @@ func TestMonthSort(t *testing.T) {
 	}
 }
 
-// Тест для функции isSorted
+// Тест проверки отсортированных данных
 func TestIsSorted(t *testing.T) {
+	isSorted := func(lines []string, config sortConfig, unique bool) bool {
+		number, _, err := findDisorder(&sliceSource{lines: lines}, config, unique)
+		if err != nil {
+			t.Fatalf("findDisorder() error: %v", err)
+		}
+		return number == 0
+	}
+
 	linesSortedByMonth := []string{
 		"January 12",
 		"February 1.5M",
@@ -109,14 +101,57 @@ func TestIsSorted(t *testing.T) {
 		"February 1.5M",
 	}
 
+	// Отсортированы по алфавиту, но не по месяцам
+	linesSortedByName := []string{
+		"August 7",
+		"December 90",
+		"February 1.5M",
+		"January 12",
+	}
+
+	byMonth := sortConfig{Global: sortOptions{Month: true}}
+
 	// Проверка сортировки по месяцам
-	if !isSorted(linesSortedByMonth, 1, false, false, false, true) {
-		t.Errorf("isSorted() failed, should be sorted by month")
+	if !isSorted(linesSortedByMonth, byMonth, false) {
+		t.Errorf("findDisorder() failed, should be sorted by month")
 	}
 
 	// Проверка несортированных данных
-	if isSorted(linesUnsorted, 1, false, false, false, true) {
-		t.Errorf("isSorted() failed, should not be sorted by month")
+	if isSorted(linesUnsorted, byMonth, false) {
+		t.Errorf("findDisorder() failed, should not be sorted by month")
+	}
+
+	// Сортировка по другому критерию не считается сортировкой по месяцам
+	if isSorted(linesSortedByName, byMonth, false) {
+		t.Errorf("findDisorder() failed, alphabetical order is not month order")
+	}
+
+	// Проверка обратного порядка
+	reversed := sortConfig{Global: sortOptions{Month: true, Reverse: true}}
+	if isSorted(linesSortedByMonth, reversed, false) {
+		t.Errorf("findDisorder() failed, should not be sorted in reverse order")
+	}
+	if !isSorted([]string{"December 90", "August 7", "January 12"}, reversed, false) {
+		t.Errorf("findDisorder() failed, should be sorted in reverse order")
+	}
+
+	// При unique равные ключи считаются нарушением порядка
+	duplicates := []string{"a 1", "b 1"}
+	byNumber := sortConfig{Keys: []keySpec{{StartField: 2, StartChar: 1, EndField: 2, Options: sortOptions{Numeric: true}}}}
+	if !isSorted(duplicates, byNumber, false) {
+		t.Errorf("findDisorder() failed, equal keys are sorted")
+	}
+	if isSorted(duplicates, byNumber, true) {
+		t.Errorf("findDisorder() failed, equal keys are not unique")
+	}
+}
+
+// Тест поиска первого нарушения порядка
+func TestFindDisorder(t *testing.T) {
+	source := &sliceSource{lines: []string{"a", "b", "b", "d", "c", "a"}}
+	number, line, err := findDisorder(source, sortConfig{}, false)
+	if err != nil || number != 5 || line != "c" {
+		t.Errorf("findDisorder() = %d, %q, %v, expected 5, \"c\"", number, line, err)
 	}
 }
 
@@ -124,19 +159,629 @@ func TestIsSorted(t *testing.T) {
 func TestParseHumanReadable(t *testing.T) {
 	tests := []struct {
 		value    string
-		expected int
+		expected float64
 	}{
 		{"1K", 1000},
 		{"1M", 1000000},
 		{"1G", 1000000000},
 		{"123", 123},
 		{"2k", 2000},
+		{"1.5M", 1500000},
+		{"-2.5K", -2500},
+		{"1Ki", 1024},
+		{"3MiB", 3 << 20},
+		{"1E", 1e18},
+		{"abc", 0},
 	}
 
 	for _, test := range tests {
 		result := parseHumanReadable(test.value)
 		if result != test.expected {
-			t.Errorf("parseHumanReadable(%s) = %d, expected %d", test.value, result, test.expected)
+			t.Errorf("parseHumanReadable(%s) = %g, expected %g", test.value, result, test.expected)
 		}
 	}
 }
+
+// Тест для функции parseSize
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected int64
+	}{
+		{"100b", 100},
+		{"10", 10 * 1024},
+		{"64K", 64 * 1024},
+		{"2M", 2 << 20},
+		{"1G", 1 << 30},
+	}
+
+	for _, test := range tests {
+		result, err := parseSize(test.value)
+		if err != nil || result != test.expected {
+			t.Errorf("parseSize(%s) = %d, %v, expected %d", test.value, result, err, test.expected)
+		}
+	}
+
+	if _, err := parseSize("lots"); err == nil {
+		t.Errorf("parseSize(lots) expected error")
+	}
+}
+
+// Тест внешней сортировки: результат совпадает с сортировкой в памяти,
+// временные файлы удаляются
+func TestExternalSort(t *testing.T) {
+	var lines []string
+	for i := 0; i < 500; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", (i*7919)%300))
+	}
+	input := strings.Join(lines, "\n") + "\n"
+
+	// Маленький бюджет и малое число одновременно сливаемых серий
+	// включают многопроходное слияние
+	defer func(fanIn int) { mergeFanIn = fanIn }(mergeFanIn)
+	mergeFanIn = 3
+
+	dir := t.TempDir()
+	cmp := sortConfig{Keys: []keySpec{{StartField: 2, StartChar: 1, EndField: 2, Options: sortOptions{Numeric: true}}}}.compare
+	for _, unique := range []bool{false, true} {
+		var out strings.Builder
+		if err := externalSort(&readerSource{reader: bufio.NewReader(strings.NewReader(input))}, &out, 400, dir, cmp, unique); err != nil {
+			t.Fatalf("externalSort() error: %v", err)
+		}
+
+		expected := append([]string(nil), lines...)
+		sortLines(expected, cmp)
+		if unique {
+			expected = slices.Compact(expected)
+		}
+		if out.String() != strings.Join(expected, "\n")+"\n" {
+			t.Errorf("externalSort(unique=%v) result differs from in-memory sort", unique)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 0 {
+		t.Errorf("Expected temporary files to be removed, found %d", len(entries))
+	}
+}
+
+// Тест для функции parseKeySpec
+func TestParseKeySpec(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected keySpec
+	}{
+		{"2", keySpec{StartField: 2, StartChar: 1}},
+		{"2,2n", keySpec{StartField: 2, StartChar: 1, EndField: 2, Options: sortOptions{Numeric: true}}},
+		{"1.3,1.5r", keySpec{StartField: 1, StartChar: 3, EndField: 1, EndChar: 5, Options: sortOptions{Reverse: true}}},
+		{"3bM,3", keySpec{StartField: 3, StartChar: 1, EndField: 3, Options: sortOptions{Month: true, IgnoreBlanks: true}}},
+		{"2,2bn", keySpec{StartField: 2, StartChar: 1, EndField: 2, Options: sortOptions{Numeric: true, IgnoreEndBlanks: true}}},
+		{"1b,1b", keySpec{StartField: 1, StartChar: 1, EndField: 1, Options: sortOptions{IgnoreBlanks: true, IgnoreEndBlanks: true}}},
+	}
+
+	for _, test := range tests {
+		result, err := parseKeySpec(test.value)
+		if err != nil || result != test.expected {
+			t.Errorf("parseKeySpec(%s) = %+v, %v, expected %+v", test.value, result, err, test.expected)
+		}
+	}
+
+	for _, value := range []string{"", "0", "1.0", "1,0", "x", "1z"} {
+		if _, err := parseKeySpec(value); err == nil {
+			t.Errorf("parseKeySpec(%q) expected error", value)
+		}
+	}
+}
+
+// Тест сортировки по нескольким ключам с собственными модификаторами
+func TestSortByKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		keys     []string
+		global   sortOptions
+		lines    []string
+		expected []string
+	}{
+		{
+			name:     "department then salary descending",
+			keys:     []string{"1,1", "2,2nr"},
+			lines:    []string{"sales 100", "it 300", "sales 200", "it 50"},
+			expected: []string{"it 300", "it 50", "sales 200", "sales 100"},
+		},
+		{
+			name:     "global options apply to keys without modifiers",
+			keys:     []string{"2,2"},
+			global:   sortOptions{Numeric: true},
+			lines:    []string{"b 10", "a 9", "c 100"},
+			expected: []string{"a 9", "b 10", "c 100"},
+		},
+		{
+			name:     "character offsets within field",
+			keys:     []string{"1.3,1.4n"},
+			lines:    []string{"id20x", "id03y", "id11z"},
+			expected: []string{"id03y", "id11z", "id20x"},
+		},
+		{
+			name:     "whole line breaks ties",
+			keys:     []string{"2,2M"},
+			lines:    []string{"b Feb", "a Feb", "c Jan"},
+			expected: []string{"c Jan", "a Feb", "b Feb"},
+		},
+	}
+
+	for _, test := range tests {
+		var keys keyList
+		for _, value := range test.keys {
+			if err := keys.Set(value); err != nil {
+				t.Fatalf("%s: keys.Set(%s) error: %v", test.name, value, err)
+			}
+		}
+		lines := append([]string(nil), test.lines...)
+		sortLines(lines, sortConfig{Keys: keys, Global: test.global}.compare)
+		if !reflect.DeepEqual(lines, test.expected) {
+			t.Errorf("%s: got %v, expected %v", test.name, lines, test.expected)
+		}
+	}
+}
+
+// Тест разделения слитно записанных значений флагов
+func TestSplitAttachedValues(t *testing.T) {
+	args := []string{"-k2,2n", "-k", "1", "-S64M", "-k=3", "-t=", "-t=,", "-t:", "-n", "--", "-kfile"}
+	expected := []string{"-k", "2,2n", "-k", "1", "-S", "64M", "-k=3", "-t", "=", "-t=,", "-t", ":", "-n", "--", "-kfile"}
+	if result := splitAttachedValues(args); !reflect.DeepEqual(result, expected) {
+		t.Errorf("splitAttachedValues() = %v, expected %v", result, expected)
+	}
+}
+
+// Тест выделения полей: разделитель и пробельные поля в стиле GNU sort
+func TestFieldSeparator(t *testing.T) {
+	tests := []struct {
+		name      string
+		separator string
+		keys      []string
+		lines     []string
+		expected  []string
+	}{
+		{
+			name:      "passwd by uid",
+			separator: ":",
+			keys:      []string{"3,3n"},
+			lines:     []string{"daemon:x:1:1::/usr/sbin", "user:x:1000:1000::/home/user", "root:x:0:0::/root"},
+			expected:  []string{"root:x:0:0::/root", "daemon:x:1:1::/usr/sbin", "user:x:1000:1000::/home/user"},
+		},
+		{
+			name:      "tsv with empty fields",
+			separator: `\t`,
+			keys:      []string{"2,2"},
+			lines:     []string{"a\tz\t1", "b\t\t2", "c\ty\t3"},
+			expected:  []string{"b\t\t2", "c\ty\t3", "a\tz\t1"},
+		},
+		{
+			name:     "leading blanks belong to the field",
+			keys:     []string{"2,2"},
+			lines:    []string{"y b", "x  c"},
+			expected: []string{"x  c", "y b"},
+		},
+		{
+			name:     "b skips leading blanks",
+			keys:     []string{"2b,2"},
+			lines:    []string{"x  c", "y b"},
+			expected: []string{"y b", "x  c"},
+		},
+		{
+			name:     "b at the end position does not apply to the start",
+			keys:     []string{"2,2b"},
+			lines:    []string{"x  b 2", "y a  1", "z   c 0", "w a 3"},
+			expected: []string{"z   c 0", "x  b 2", "w a 3", "y a  1"},
+		},
+		{
+			name:      "b keeps trailing blanks",
+			separator: ",",
+			keys:      []string{"1,1b"},
+			lines:     []string{"a ,1", "a,2"},
+			expected:  []string{"a,2", "a ,1"},
+		},
+		{
+			name:     "b applies to character offsets",
+			keys:     []string{"2.2b,2.2"},
+			lines:    []string{"x   ab", "y bc"},
+			expected: []string{"x   ab", "y bc"},
+		},
+	}
+
+	for _, test := range tests {
+		separator, err := parseSeparator(test.separator)
+		if err != nil {
+			t.Fatalf("%s: parseSeparator() error: %v", test.name, err)
+		}
+		var keys keyList
+		for _, value := range test.keys {
+			if err := keys.Set(value); err != nil {
+				t.Fatalf("%s: keys.Set(%s) error: %v", test.name, value, err)
+			}
+		}
+		lines := append([]string(nil), test.lines...)
+		sortLines(lines, sortConfig{Keys: keys, Separator: separator}.compare)
+		if !reflect.DeepEqual(lines, test.expected) {
+			t.Errorf("%s: got %q, expected %q", test.name, lines, test.expected)
+		}
+	}
+
+	if _, err := parseSeparator("::"); err == nil {
+		t.Errorf("parseSeparator(::) expected error")
+	}
+}
+
+// Тест устойчивой сортировки: строки с равными ключами сохраняют порядок ввода
+func TestStableSort(t *testing.T) {
+	lines := []string{"b 1", "c 0", "a 1", "B 1"}
+	sortLines(lines, sortConfig{Keys: []keySpec{{StartField: 2, StartChar: 1, EndField: 2}}, Stable: true}.compare)
+
+	expected := []string{"c 0", "b 1", "a 1", "B 1"}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("stable sort = %v, expected %v", lines, expected)
+	}
+}
+
+// Тест сортировки нескольких файлов с записью результата в один из них
+func TestSortInputInPlace(t *testing.T) {
+	for _, budget := range []int64{0, 16} {
+		dir := t.TempDir()
+		first := dir + "/first.txt"
+		second := dir + "/second.txt"
+		if err := os.WriteFile(first, []byte("delta\nalpha\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		// Последняя строка без перевода строки не склеивается со следующим файлом
+		if err := os.WriteFile(second, []byte("charlie\nbravo"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		input := &inputSource{names: []string{first, second}}
+		output := &outputFile{name: first}
+		writer := bufio.NewWriter(output)
+		if err := sortInput(input, writer, sortConfig{}, budget, false, 2); err != nil {
+			t.Fatalf("sortInput(budget=%d) error: %v", budget, err)
+		}
+		if err := writer.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if err := output.Close(); err != nil {
+			t.Fatal(err)
+		}
+		input.close()
+
+		data, err := os.ReadFile(first)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := "alpha\nbravo\ncharlie\ndelta\n"; string(data) != expected {
+			t.Errorf("sortInput(budget=%d) wrote %q, expected %q", budget, data, expected)
+		}
+	}
+}
+
+// Тест сравнения по правилам локали
+func TestCollation(t *testing.T) {
+	collation, err := newCollation("ru")
+	if err != nil {
+		t.Fatalf("newCollation(ru) error: %v", err)
+	}
+
+	lines := []string{"яблоко", "Ель", "apple", "ёж", "Банан", "еда", "Zebra"}
+	sortLines(lines, sortConfig{Collation: collation}.compare)
+
+	expected := []string{"apple", "Zebra", "Банан", "еда", "ёж", "Ель", "яблоко"}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("collated sort = %v, expected %v", lines, expected)
+	}
+
+	if _, err := newCollation("not a locale"); err == nil {
+		t.Errorf("newCollation(not a locale) expected error")
+	}
+}
+
+// Тест сортировки номеров версий
+func TestVersionSort(t *testing.T) {
+	lines := []string{"file10", "file2", "file1.10", "file1.9", "file", "file02a"}
+	sortLines(lines, sortConfig{Global: sortOptions{Version: true}}.compare)
+
+	expected := []string{"file", "file1.9", "file1.10", "file2", "file02a", "file10"}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("version sort = %v, expected %v", lines, expected)
+	}
+}
+
+// Тест числовых режимов сортировки -n, -g и -h
+func TestNumericModes(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  sortOptions
+		lines    []string
+		expected []string
+	}{
+		{
+			name:     "numeric with signs, decimals and thousands separators",
+			options:  sortOptions{Numeric: true},
+			lines:    []string{"1,234", "-3", "1.5", "abc", "-0.5", "200", "1e3", "  7"},
+			expected: []string{"-3", "-0.5", "abc", "1e3", "1.5", "  7", "200", "1,234"},
+		},
+		{
+			name:     "general floats with inf and nan",
+			options:  sortOptions{General: true},
+			lines:    []string{"1e3", "inf", "nan", "-inf", "text", "2.5", "-1E-2"},
+			expected: []string{"text", "nan", "-inf", "-1E-2", "2.5", "1e3", "inf"},
+		},
+		{
+			name:     "human readable sizes",
+			options:  sortOptions{Human: true},
+			lines:    []string{"1.5M", "45K", "2G", "900", "1Mi", "12"},
+			expected: []string{"12", "900", "45K", "1Mi", "1.5M", "2G"},
+		},
+	}
+
+	for _, test := range tests {
+		lines := append([]string(nil), test.lines...)
+		sortLines(lines, sortConfig{Global: test.options}.compare)
+		if !reflect.DeepEqual(lines, test.expected) {
+			t.Errorf("%s: got %q, expected %q", test.name, lines, test.expected)
+		}
+	}
+
+	// Сравнение по цифрам не теряет точность на длинных числах
+	a, _ := parseDecimal("123456789012345678901")
+	b, _ := parseDecimal("123456789012345678902")
+	if a.compare(b) >= 0 {
+		t.Errorf("decimal compare lost precision")
+	}
+}
+
+// Тест параллельной сортировки: результат совпадает с сортировкой через compare
+func TestParallelSort(t *testing.T) {
+	lines := benchmarkLines(2000)
+	lines = append(lines, "", "ёжик 5 1.5K", "Ель 5 2K", "apple 5 nan")
+
+	collation, err := newCollation("ru")
+	if err != nil {
+		t.Fatal(err)
+	}
+	configs := map[string]sortConfig{
+		"whole line": {},
+		"keys":       {Keys: mustKeys(t, "2,2n", "1,1r")},
+		"human":      {Keys: mustKeys(t, "3,3h")},
+		"general":    {Keys: mustKeys(t, "3g,3")},
+		"version":    {Global: sortOptions{Version: true, Reverse: true}},
+		"month":      {Global: sortOptions{Month: true}},
+		"stable":     {Keys: mustKeys(t, "2,2n"), Stable: true},
+		"collation":  {Keys: mustKeys(t, "1,1"), Collation: collation},
+		"random":     {Keys: mustKeys(t, "2,2R"), Salt: []byte("seed")},
+	}
+
+	for name, config := range configs {
+		expected := append([]string(nil), lines...)
+		sortLines(expected, config.compare)
+		for _, workers := range []int{1, 3, 8} {
+			result := append([]string(nil), lines...)
+			parallelSort(result, config, workers)
+			if !reflect.DeepEqual(result, expected) {
+				t.Errorf("%s: parallelSort(workers=%d) differs from sortLines", name, workers)
+			}
+		}
+	}
+}
+
+// Разбор списка ключей для тестов
+func mustKeys(t testing.TB, values ...string) []keySpec {
+	var keys keyList
+	for _, value := range values {
+		if err := keys.Set(value); err != nil {
+			t.Fatalf("keys.Set(%s) error: %v", value, err)
+		}
+	}
+	return keys
+}
+
+// Строки для тестов и бенчмарков: имя, номер отдела и размер
+func benchmarkLines(n int) []string {
+	names := []string{"sales", "it", "hr", "ops", "legal", "Mar", "Jan", "file10", "file2"}
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("%s%d %d %d.%dM", names[i%len(names)], (i*7919)%97, (i*104729)%1000, i%13, i%10)
+	}
+	return lines
+}
+
+// Сравнение последовательной сортировки с compare и параллельной сортировки
+// с заранее извлечёнными ключами
+func BenchmarkSort(b *testing.B) {
+	lines := benchmarkLines(100000)
+	config := sortConfig{Keys: mustKeys(b, "2,2n", "3,3h", "1,1")}
+
+	b.Run("serial", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			data := append([]string(nil), lines...)
+			sortLines(data, config.compare)
+		}
+	})
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("parallel-%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				data := append([]string(nil), lines...)
+				parallelSort(data, config, workers)
+			}
+		})
+	}
+}
+
+// Тест уникальности по ключам: из строк с равными ключами остаётся первая во входе
+func TestUniqueByKey(t *testing.T) {
+	input := "b 1\na 01\nc 2\na 1\nd 2\n"
+	config := sortConfig{Keys: mustKeys(t, "2,2n")}
+
+	for _, budget := range []int64{0, 16} {
+		var out strings.Builder
+		source := &readerSource{reader: bufio.NewReader(strings.NewReader(input))}
+		if err := sortInput(source, &out, config, budget, true, 2); err != nil {
+			t.Fatalf("sortInput(budget=%d) error: %v", budget, err)
+		}
+		if expected := "b 1\nc 2\n"; out.String() != expected {
+			t.Errorf("sortInput(budget=%d) = %q, expected %q", budget, out.String(), expected)
+		}
+	}
+}
+
+// Тест слияния отсортированных файлов с записью результата в один из входов
+func TestMergeInputs(t *testing.T) {
+	defer func(fanIn int) { mergeFanIn = fanIn }(mergeFanIn)
+	mergeFanIn = 2
+
+	dir := t.TempDir()
+	var names []string
+	for i, data := range []string{"a 1\nc 3\n", "b 1\nd 4\n", "a 2\ne 5\n"} {
+		name := fmt.Sprintf("%s/shard%d.txt", dir, i)
+		if err := os.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+
+	output := &outputFile{name: names[0]}
+	config := sortConfig{Keys: mustKeys(t, "2,2n")}
+	if err := mergeInputs(names, output, names[0], dir, config, true); err != nil {
+		t.Fatalf("mergeInputs() error: %v", err)
+	}
+	if err := output.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(names[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "a 1\na 2\nc 3\nd 4\ne 5\n"; string(data) != expected {
+		t.Errorf("mergeInputs() wrote %q, expected %q", data, expected)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != len(names) {
+		t.Errorf("Expected temporary files to be removed, found %d files", len(entries))
+	}
+}
+
+// Тест сортировки CSV: поля в кавычках, колонки по имени и заголовок
+func TestSortCSV(t *testing.T) {
+	input := "name,department,salary\n" +
+		"\"Smith, John\",sales,100\n" +
+		"\"Doe, Jane\",it,\"1,200\"\n" +
+		"Petrov,\"sales\nnorth\",300\n" +
+		"\n" +
+		"Ivanov,sales,200"
+
+	tests := []struct {
+		name     string
+		keys     []string
+		header   bool
+		unique   bool
+		expected string
+	}{
+		{
+			name:   "named columns with header",
+			keys:   []string{"department", "salary:nr"},
+			header: true,
+			expected: "name,department,salary\n" +
+				"\"Doe, Jane\",it,\"1,200\"\n" +
+				"Ivanov,sales,200\n" +
+				"\"Smith, John\",sales,100\n" +
+				"Petrov,\"sales\nnorth\",300\n",
+		},
+		{
+			name:   "numbered column without header",
+			keys:   []string{"1,1"},
+			header: false,
+			expected: "\"Doe, Jane\",it,\"1,200\"\n" +
+				"Ivanov,sales,200\n" +
+				"Petrov,\"sales\nnorth\",300\n" +
+				"\"Smith, John\",sales,100\n" +
+				"name,department,salary\n",
+		},
+		{
+			name:   "unique by column keeps the first record",
+			keys:   []string{"2,2.5"},
+			header: true,
+			unique: true,
+			expected: "name,department,salary\n" +
+				"\"Doe, Jane\",it,\"1,200\"\n" +
+				"\"Smith, John\",sales,100\n",
+		},
+	}
+
+	for _, test := range tests {
+		var out strings.Builder
+		config := sortConfig{Keys: mustKeys(t, test.keys...)}
+		if err := sortCSV(strings.NewReader(input), &out, config, test.header, test.unique, 2); err != nil {
+			t.Fatalf("%s: sortCSV() error: %v", test.name, err)
+		}
+		if out.String() != test.expected {
+			t.Errorf("%s: sortCSV() = %q, expected %q", test.name, out.String(), test.expected)
+		}
+	}
+
+	var out strings.Builder
+	config := sortConfig{Keys: mustKeys(t, "missing")}
+	if err := sortCSV(strings.NewReader(input), &out, config, true, false, 1); err == nil {
+		t.Errorf("sortCSV() expected error for unknown column")
+	}
+}
+
+// Тест случайного порядка: равные ключи идут подряд, порядок задаётся солью
+func TestRandomSort(t *testing.T) {
+	var lines []string
+	for i := 0; i < 200; i++ {
+		lines = append(lines, fmt.Sprintf("line%d %d", i, i%20))
+	}
+
+	shuffle := func(seed string) []string {
+		salt, err := randomSalt(seed, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := append([]string(nil), lines...)
+		sortLines(result, sortConfig{Keys: mustKeys(t, "2,2R"), Salt: salt}.compare)
+		return result
+	}
+
+	first := shuffle("ci-42")
+	if !reflect.DeepEqual(first, shuffle("ci-42")) {
+		t.Errorf("Expected the same order for the same seed")
+	}
+	if reflect.DeepEqual(first, shuffle("ci-43")) {
+		t.Errorf("Expected a different order for a different seed")
+	}
+
+	// Группы равных ключей не разрываются
+	seen := make(map[string]bool)
+	prev := ""
+	for _, line := range first {
+		key := strings.Fields(line)[1]
+		if key != prev && seen[key] {
+			t.Fatalf("Key %s is split into several groups", key)
+		}
+		seen[key], prev = true, key
+	}
+
+	// Порядок групп отличается от числового
+	numeric := append([]string(nil), first...)
+	sortLines(numeric, sortConfig{Keys: mustKeys(t, "2,2n")}.compare)
+	if reflect.DeepEqual(first, numeric) {
+		t.Errorf("Expected shuffled order of groups")
+	}
+
+	// Соль из файла --random-source
+	source := t.TempDir() + "/random"
+	if err := os.WriteFile(source, []byte("0123456789abcdef0123"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if salt, err := randomSalt("", source); err != nil || string(salt) != "0123456789abcdef" {
+		t.Errorf("randomSalt() = %q, %v, expected the first 16 bytes of the file", salt, err)
+	}
+}
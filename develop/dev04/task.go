@@ -16,58 +16,556 @@ package main
 Все слова должны быть приведены к нижнему регистру.
 В результате каждое слово должно встречаться только один раз.
 
+Дополнительно: индекс анаграмм по словарю из файла (-dict) с поиском анаграмм
+слова и сохранением на диск (-index) для быстрого перезапуска. Поиск слов,
+составленных из части заданных букв (-sub), и фраз из нескольких слов,
+составленных из всех букв (-phrase). Перед вычислением сигнатуры слова могут
+нормализоваться (-norm, -fold-yo, -letters-only), в результате слова остаются
+в исходном написании.
+
 Программа должна проходить все тесты. Код должен проходить проверки go vet и golint.
 */
 
 import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
 )
 
-// Функция для сортировки букв в строке
+// Функция для сортировки букв в строке; комбинируемые знаки (й в NFD)
+// остаются при своей букве
 func sortString(s string) string {
-	r := []rune(s)
-	sort.Slice(r, func(i, j int) bool {
-		return r[i] < r[j]
-	})
-	return string(r)
+	units := letterUnits(s)
+	sort.Strings(units)
+	return strings.Join(units, "")
+}
+
+// Разбиение строки на буквы вместе со следующими за ними комбинируемыми знаками
+func letterUnits(s string) []string {
+	var units []string
+	for len(s) > 0 {
+		_, size := utf8.DecodeRuneInString(s)
+		for size < len(s) {
+			r, n := utf8.DecodeRuneInString(s[size:])
+			if !unicode.Is(unicode.Mn, r) {
+				break
+			}
+			size += n
+		}
+		units = append(units, s[:size])
+		s = s[size:]
+	}
+	return units
 }
 
 // Функция для поиска множеств анаграмм по словарю
 func findAnagrams(words []string) map[string][]string {
-	// Создание карты для группировки анаграмм
-	anagrams := make(map[string][]string)
-	wordMap := make(map[string]string) // для отслеживания первого встретившегося слова
+	return findAnagramsWithOptions(words, normOptions{})
+}
 
-	// Приведение всех слов к нижнему регистру
+// Поиск множеств анаграмм с нормализацией слов
+func findAnagramsWithOptions(words []string, opts normOptions) map[string][]string {
+	index := newAnagramIndexWithOptions(opts)
 	for _, word := range words {
-		lowerWord := strings.ToLower(word)
-		sortedWord := sortString(lowerWord)
+		index.add(word)
+	}
+	return index.sets()
+}
+
+// ================== Нормализация ==================
+
+// normOptions — нормализация слова перед вычислением сигнатуры
+type normOptions struct {
+	Form        string // "nfc" или "nfd" — нормальная форма Unicode; "" — без приведения
+	FoldYo      bool   // ё считается буквой е
+	LettersOnly bool   // небуквенные символы (дефисы, знаки препинания, цифры) не учитываются
+}
+
+// Разбор нормальной формы Unicode (флаг -norm)
+func parseNormForm(value string) (string, error) {
+	switch form := strings.ToLower(value); form {
+	case "", "nfc", "nfd":
+		return form, nil
+	}
+	return "", fmt.Errorf("unknown normalization form %q (expected nfc or nfd)", value)
+}
+
+// Нормализация слова: нижний регистр, затем выбранные преобразования
+func (o normOptions) normalize(word string) string {
+	word = strings.ToLower(word)
+	if o.FoldYo {
+		// В NFD ё — это е и комбинируемый знак, поэтому сначала слово собирается
+		word = strings.ReplaceAll(norm.NFC.String(word), "ё", "е")
+	}
+	switch o.Form {
+	case "nfc":
+		word = norm.NFC.String(word)
+	case "nfd":
+		word = norm.NFD.String(word)
+	}
+	if o.LettersOnly {
+		// Комбинируемые знаки (й в NFD) остаются вместе с буквами
+		word = strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) || unicode.Is(unicode.Mn, r) {
+				return r
+			}
+			return -1
+		}, word)
+	}
+	return word
+}
+
+// ================== Индекс анаграмм ==================
+
+// anagramGroup — слова с общей сигнатурой (отсортированными буквами)
+type anagramGroup struct {
+	Signature string
+	First     string   // первое встретившееся в словаре слово
+	Words     []string // слова по возрастанию, без повторов
+}
+
+// anagramIndex — индекс анаграмм: группы слов по сигнатуре. Поиск анаграмм
+// слова — вычисление сигнатуры и одно обращение к map.
+type anagramIndex struct {
+	mu         sync.RWMutex
+	opts       normOptions
+	dictionary string // контрольная сумма словаря, по которому построен индекс
+	groups     map[string]*anagramGroup
+}
+
+// Создание пустого индекса
+func newAnagramIndex() *anagramIndex {
+	return newAnagramIndexWithOptions(normOptions{})
+}
+
+// Создание пустого индекса с нормализацией слов
+func newAnagramIndexWithOptions(opts normOptions) *anagramIndex {
+	return &anagramIndex{opts: opts, groups: make(map[string]*anagramGroup)}
+}
+
+// Сигнатура слова: отсортированные буквы нормализованного слова
+func (idx *anagramIndex) signature(word string) string {
+	return sortString(idx.opts.normalize(word))
+}
+
+// Добавление слова в индекс; слово приводится к нижнему регистру,
+// нормализация влияет на сигнатуру и поиск повторов. Из написаний,
+// совпадающих после нормализации, остаётся первое.
+func (idx *anagramIndex) add(word string) {
+	word = strings.ToLower(strings.TrimSpace(word))
+	normalized := idx.opts.normalize(word)
+	signature := sortString(normalized)
+	if signature == "" {
+		return
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
 
-		if _, found := anagrams[sortedWord]; !found {
-			wordMap[sortedWord] = lowerWord // первое встретившееся слово
+	group, found := idx.groups[signature]
+	if !found {
+		group = &anagramGroup{Signature: signature, First: word}
+		idx.groups[signature] = group
+	}
+	// Слова группы хранятся отсортированными, повторы пропускаются
+	for _, w := range group.Words {
+		if idx.opts.normalize(w) == normalized {
+			return
 		}
-		anagrams[sortedWord] = append(anagrams[sortedWord], lowerWord)
 	}
+	i := sort.SearchStrings(group.Words, word)
+	group.Words = append(group.Words, "")
+	copy(group.Words[i+1:], group.Words[i:])
+	group.Words[i] = word
+}
+
+// Все анаграммы слова из словаря, кроме самого слова (в любом написании,
+// совпадающем после нормализации), по возрастанию
+func (idx *anagramIndex) lookup(word string) []string {
+	normalized := idx.opts.normalize(strings.TrimSpace(word))
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	group, found := idx.groups[sortString(normalized)]
+	if !found {
+		return nil
+	}
+	var result []string
+	for _, w := range group.Words {
+		if idx.opts.normalize(w) != normalized {
+			result = append(result, w)
+		}
+	}
+	return result
+}
+
+// Все множества анаграмм из двух и более слов: ключ — первое встретившееся
+// слово множества, значение — слова по возрастанию
+func (idx *anagramIndex) sets() map[string][]string {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
 
-	// Формирование результирующей карты множеств анаграмм
 	result := make(map[string][]string)
-	for key, group := range anagrams {
-		if len(group) > 1 {
-			sort.Strings(group) // сортировка по возрастанию
-			result[wordMap[key]] = group
+	for _, group := range idx.groups {
+		if len(group.Words) > 1 {
+			result[group.First] = append([]string(nil), group.Words...)
+		}
+	}
+	return result
+}
+
+// ================== Поиск по набору букв ==================
+
+// Вычитание мультимножества букв sub из letters (обе строки — сигнатуры,
+// буквы по возрастанию). Буква вычитается вместе с комбинируемыми знаками.
+// ok == false, если в letters не хватает букв.
+func subtractLetters(letters, sub string) (rest string, ok bool) {
+	l, s := letterUnits(letters), letterUnits(sub)
+	var result []string
+	i := 0
+	for _, u := range s {
+		for i < len(l) && l[i] < u {
+			result = append(result, l[i])
+			i++
 		}
+		if i == len(l) || l[i] != u {
+			return "", false
+		}
+		i++
 	}
+	return strings.Join(append(result, l[i:]...), ""), true
+}
+
+// Сигнатура набора букв: пробелы не учитываются
+func (idx *anagramIndex) lettersSignature(letters string) string {
+	return idx.signature(strings.Join(strings.Fields(letters), ""))
+}
+
+// Группы, сигнатура которых — подмножество букв letters
+func (idx *anagramIndex) subGroups(letters string) []*anagramGroup {
+	var groups []*anagramGroup
+	for _, group := range idx.groups {
+		if len(group.Signature) > len(letters) {
+			continue
+		}
+		if _, ok := subtractLetters(letters, group.Signature); ok {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
 
+// Слова словаря, составленные из части букв letters (каждая буква
+// используется не больше раз, чем встречается). Слова упорядочены по
+// убыванию длины, затем по алфавиту; limit > 0 ограничивает число слов.
+func (idx *anagramIndex) subAnagrams(letters string, limit int) []string {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	var result []string
+	for _, group := range idx.subGroups(idx.lettersSignature(letters)) {
+		result = append(result, group.Words...)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		li, lj := len([]rune(result[i])), len([]rune(result[j]))
+		if li != lj {
+			return li > lj
+		}
+		return result[i] < result[j]
+	})
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
 	return result
 }
 
+// Фразы не более чем из maxWords слов словаря, вместе составленные ровно из
+// букв phrase (пробелы не учитываются). Первыми находятся фразы из длинных
+// слов; limit > 0 ограничивает число фраз.
+func (idx *anagramIndex) phraseAnagrams(phrase string, maxWords, limit int) [][]string {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	letters := idx.lettersSignature(phrase)
+	groups := idx.subGroups(letters)
+	sort.Slice(groups, func(i, j int) bool {
+		if len(groups[i].Signature) != len(groups[j].Signature) {
+			return len(groups[i].Signature) > len(groups[j].Signature)
+		}
+		return groups[i].Signature < groups[j].Signature
+	})
+
+	var result [][]string
+	var chosen []*anagramGroup
+	// Перебор наборов групп; группы берутся в порядке списка, чтобы
+	// одна и та же фраза не находилась в разных перестановках
+	var search func(rest string, start int) bool
+	search = func(rest string, start int) bool {
+		if rest == "" {
+			return appendPhrases(&result, chosen, limit)
+		}
+		if len(chosen) == maxWords {
+			return true
+		}
+		for i := start; i < len(groups); i++ {
+			next, ok := subtractLetters(rest, groups[i].Signature)
+			if !ok {
+				continue
+			}
+			chosen = append(chosen, groups[i])
+			more := search(next, i)
+			chosen = chosen[:len(chosen)-1]
+			if !more {
+				return false
+			}
+		}
+		return true
+	}
+	if letters != "" && maxWords > 0 {
+		search(letters, 0)
+	}
+	return result
+}
+
+// Добавление всех фраз из слов выбранных групп; false, если достигнут limit
+func appendPhrases(result *[][]string, groups []*anagramGroup, limit int) bool {
+	phrase := make([]string, len(groups))
+	var build func(i int) bool
+	build = func(i int) bool {
+		if i == len(groups) {
+			*result = append(*result, append([]string(nil), phrase...))
+			return limit <= 0 || len(*result) < limit
+		}
+		for _, word := range groups[i].Words {
+			// Слова одной группы в фразе идут по возрастанию
+			if i > 0 && groups[i] == groups[i-1] && word < phrase[i-1] {
+				continue
+			}
+			phrase[i] = word
+			if !build(i + 1) {
+				return false
+			}
+		}
+		return true
+	}
+	return build(0)
+}
+
+// Загрузка словаря: одно слово в строке, пустые строки пропускаются
+func loadDictionary(r io.Reader, opts normOptions) (*anagramIndex, error) {
+	index := newAnagramIndexWithOptions(opts)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		index.add(scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// Версия формата файла индекса
+const indexVersion = 5
+
+// indexFile — содержимое файла индекса
+type indexFile struct {
+	Version    int
+	Options    normOptions
+	Dictionary string
+	Groups     []anagramGroup
+}
+
+var errIndexVersion = errors.New("unsupported index version")
+
+// Сохранение индекса в формате gob
+func (idx *anagramIndex) save(w io.Writer) error {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	file := indexFile{Version: indexVersion, Options: idx.opts, Dictionary: idx.dictionary, Groups: make([]anagramGroup, 0, len(idx.groups))}
+	for _, group := range idx.groups {
+		file.Groups = append(file.Groups, *group)
+	}
+	return gob.NewEncoder(w).Encode(file)
+}
+
+// Чтение индекса, сохранённого save
+func readIndex(r io.Reader) (*anagramIndex, error) {
+	var file indexFile
+	if err := gob.NewDecoder(r).Decode(&file); err != nil {
+		return nil, err
+	}
+	if file.Version != indexVersion {
+		return nil, fmt.Errorf("%w: %d", errIndexVersion, file.Version)
+	}
+
+	index := &anagramIndex{opts: file.Options, dictionary: file.Dictionary, groups: make(map[string]*anagramGroup, len(file.Groups))}
+	for i := range file.Groups {
+		index.groups[file.Groups[i].Signature] = &file.Groups[i]
+	}
+	return index, nil
+}
+
+// Сохранение индекса в файл: запись во временный файл и переименование,
+// чтобы при сбое не остался недописанный индекс
+func saveIndexFile(idx *anagramIndex, path string) error {
+	file, err := os.CreateTemp(filepath.Dir(path), ".anagram-index-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	writer := bufio.NewWriter(file)
+	if err := idx.save(writer); err != nil {
+		file.Close()
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+var errIndexOptions = errors.New("index was built with different normalization options")
+
+// Открытие индекса: из файла индекса, если он есть, построен с теми же
+// параметрами нормализации и по тому же словарю, иначе из словаря;
+// построенный по словарю индекс сохраняется в файл индекса. Без файла
+// словаря используется сохранённый индекс.
+func openIndex(dictPath, indexPath string, opts normOptions) (*anagramIndex, error) {
+	var dict *os.File
+	var sum string
+	if dictPath != "" {
+		file, err := os.Open(dictPath)
+		switch {
+		case err == nil:
+			defer file.Close()
+			if sum, err = checksum(file); err != nil {
+				return nil, err
+			}
+			dict = file
+		case !os.IsNotExist(err) || indexPath == "":
+			return nil, err
+		}
+	}
+
+	if indexPath != "" {
+		index, err := readIndexFile(indexPath)
+		switch {
+		case err == nil && index.opts == opts && (dict == nil || index.dictionary == sum):
+			return index, nil
+		case err == nil && dict == nil:
+			return nil, errIndexOptions
+		case err != nil && (dict == nil || !os.IsNotExist(err) && !errors.Is(err, errIndexVersion)):
+			return nil, err
+		}
+	}
+
+	if _, err := dict.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	index, err := loadDictionary(dict, opts)
+	if err != nil {
+		return nil, err
+	}
+	index.dictionary = sum
+	if indexPath != "" {
+		if err := saveIndexFile(index, indexPath); err != nil {
+			return nil, err
+		}
+	}
+	return index, nil
+}
+
+// Контрольная сумма содержимого словаря
+func checksum(r io.Reader) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Чтение индекса из файла
+func readIndexFile(path string) (*anagramIndex, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return readIndex(bufio.NewReader(file))
+}
+
 func main() {
-	words := []string{"пятак", "пятка", "тяпка", "листок", "слиток", "столик", "волчок"}
-	anagrams := findAnagrams(words)
+	dictPath := flag.String("dict", "", "Dictionary file, one word per line")
+	indexPath := flag.String("index", "", "Index file: loaded if it exists and matches -dict, otherwise rebuilt from -dict and saved")
+	sub := flag.Bool("sub", false, "Find words made of a subset of the given letters")
+	phrase := flag.Bool("phrase", false, "Find phrases of several words made of all the given letters")
+	maxWords := flag.Int("max-words", 2, "Maximum number of words in a phrase (with -phrase)")
+	limit := flag.Int("limit", 100, "Maximum number of results per query (0 means unlimited)")
+	form := flag.String("norm", "", "Unicode normalization form applied before matching: nfc or nfd")
+	foldYo := flag.Bool("fold-yo", false, "Treat ё as е when matching")
+	lettersOnly := flag.Bool("letters-only", false, "Ignore hyphens, punctuation and other non-letters when matching")
+	flag.Parse()
 
-	for key, group := range anagrams {
-		fmt.Printf("%s: %v\n", key, group)
+	normForm, err := parseNormForm(*form)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
 	}
-}
\ No newline at end of file
+	opts := normOptions{Form: normForm, FoldYo: *foldYo, LettersOnly: *lettersOnly}
+
+	// Без словаря — слова из примера
+	var index *anagramIndex
+	if *dictPath == "" && *indexPath == "" {
+		index = newAnagramIndexWithOptions(opts)
+		for _, word := range []string{"пятак", "пятка", "тяпка", "листок", "слиток", "столик", "волчок"} {
+			index.add(word)
+		}
+	} else {
+		index, err = openIndex(*dictPath, *indexPath, opts)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+	}
+
+	// Слова из аргументов — запросы, без аргументов — все множества анаграмм
+	for _, query := range flag.Args() {
+		switch {
+		case *sub:
+			fmt.Printf("%s: %v\n", query, index.subAnagrams(query, *limit))
+		case *phrase:
+			fmt.Printf("%s:\n", query)
+			for _, words := range index.phraseAnagrams(query, *maxWords, *limit) {
+				fmt.Println(" ", strings.Join(words, " "))
+			}
+		default:
+			fmt.Printf("%s: %v\n", query, index.lookup(query))
+		}
+	}
+	if flag.NArg() == 0 {
+		for key, group := range index.sets() {
+			fmt.Printf("%s: %v\n", key, group)
+		}
+	}
+}
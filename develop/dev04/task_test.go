@@ -1,7 +1,14 @@
 package main
 
 import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -53,4 +60,339 @@ func TestSortString(t *testing.T) {
 			t.Errorf("For input %s, expected %s, but got %s", test.input, test.expected, result)
 		}
 	}
-}
\ No newline at end of file
+}
+
+// Тест индекса анаграмм: поиск анаграмм слова и повторы в словаре
+func TestAnagramIndex(t *testing.T) {
+	dictionary := "пятак\nПятка\n\nтяпка\nлисток\nслиток\nпятак\nволчок\n"
+	index, err := loadDictionary(strings.NewReader(dictionary), normOptions{})
+	if err != nil {
+		t.Fatalf("loadDictionary() error: %v", err)
+	}
+
+	tests := []struct {
+		word     string
+		expected []string
+	}{
+		{"пятак", []string{"пятка", "тяпка"}},
+		{"Тяпка", []string{"пятак", "пятка"}},
+		{"катяп", []string{"пятак", "пятка", "тяпка"}},
+		{"волчок", nil},
+		{"кот", nil},
+	}
+	for _, test := range tests {
+		result := index.lookup(test.word)
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("lookup(%s) = %v, expected %v", test.word, result, test.expected)
+		}
+	}
+
+	expected := map[string][]string{
+		"пятак":  {"пятак", "пятка", "тяпка"},
+		"листок": {"листок", "слиток"},
+	}
+	if result := index.sets(); !reflect.DeepEqual(result, expected) {
+		t.Errorf("sets() = %v, expected %v", result, expected)
+	}
+}
+
+// Тест сохранения и загрузки индекса
+func TestIndexSaveLoad(t *testing.T) {
+	index := newAnagramIndex()
+	for _, word := range []string{"листок", "слиток", "столик", "кот", "ток"} {
+		index.add(word)
+	}
+
+	var buf bytes.Buffer
+	if err := index.save(&buf); err != nil {
+		t.Fatalf("save() error: %v", err)
+	}
+	loaded, err := readIndex(&buf)
+	if err != nil {
+		t.Fatalf("readIndex() error: %v", err)
+	}
+	if !reflect.DeepEqual(loaded.sets(), index.sets()) {
+		t.Errorf("Loaded index sets %v, expected %v", loaded.sets(), index.sets())
+	}
+	if result := loaded.lookup("кто"); !reflect.DeepEqual(result, []string{"кот", "ток"}) {
+		t.Errorf("lookup(кто) after load = %v", result)
+	}
+
+	// Индекс строится по словарю один раз, затем читается из файла
+	dir := t.TempDir()
+	dictPath := filepath.Join(dir, "dict.txt")
+	indexPath := filepath.Join(dir, "dict.idx")
+	if err := os.WriteFile(dictPath, []byte("кот\nток\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openIndex(dictPath, indexPath, normOptions{}); err != nil {
+		t.Fatalf("openIndex() error: %v", err)
+	}
+	os.Remove(dictPath)
+	reopened, err := openIndex(dictPath, indexPath, normOptions{})
+	if err != nil {
+		t.Fatalf("openIndex() from index file error: %v", err)
+	}
+	if result := reopened.lookup("кот"); !reflect.DeepEqual(result, []string{"ток"}) {
+		t.Errorf("lookup(кот) from index file = %v", result)
+	}
+
+	// Файл другой версии не читается
+	buf.Reset()
+	if err := gob.NewEncoder(&buf).Encode(indexFile{Version: indexVersion + 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readIndex(&buf); !errors.Is(err, errIndexVersion) {
+		t.Errorf("readIndex() error = %v, expected %v", err, errIndexVersion)
+	}
+}
+
+// Тест поиска слов из части букв
+func TestSubAnagrams(t *testing.T) {
+	index := newAnagramIndex()
+	for _, word := range []string{"кот", "ток", "кто", "сок", "коса", "оса", "кокос", "лиса"} {
+		index.add(word)
+	}
+
+	tests := []struct {
+		letters  string
+		limit    int
+		expected []string
+	}{
+		{"кросат", 0, []string{"коса", "кот", "кто", "оса", "сок", "ток"}},
+		{"Кросат", 3, []string{"коса", "кот", "кто"}},
+		{"ксоко", 0, []string{"кокос", "сок"}},
+		{"ко", 0, nil},
+	}
+	for _, test := range tests {
+		result := index.subAnagrams(test.letters, test.limit)
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("subAnagrams(%s, %d) = %v, expected %v", test.letters, test.limit, result, test.expected)
+		}
+	}
+
+	// В NFD й — это и с комбинируемым знаком; знак не отделяется от буквы
+	nfd := newAnagramIndexWithOptions(normOptions{Form: "nfd"})
+	for _, word := range []string{"и", "й", "иод", "йод"} {
+		nfd.add(word)
+	}
+	if result := nfd.subAnagrams("й", 0); !reflect.DeepEqual(result, []string{"й"}) {
+		t.Errorf("subAnagrams(й) under nfd = %v, expected [й]", result)
+	}
+	if result := nfd.subAnagrams("дой", 0); !reflect.DeepEqual(result, []string{"йод", "й"}) {
+		t.Errorf("subAnagrams(дой) under nfd = %v, expected [йод й]", result)
+	}
+	if result := nfd.phraseAnagrams("йоди", 2, 0); !reflect.DeepEqual(result, [][]string{{"йод", "и"}, {"иод", "й"}}) {
+		t.Errorf("phraseAnagrams(йоди) under nfd = %v, expected [[йод и] [иод й]]", result)
+	}
+}
+
+// Тест поиска фраз из нескольких слов
+func TestPhraseAnagrams(t *testing.T) {
+	index := newAnagramIndex()
+	for _, word := range []string{"кот", "ток", "сон", "нос", "котсон", "кто", "он", "с"} {
+		index.add(word)
+	}
+
+	result := index.phraseAnagrams("Носок т", 2, 0)
+	expected := [][]string{
+		{"котсон"},
+		{"кот", "нос"}, {"кот", "сон"},
+		{"кто", "нос"}, {"кто", "сон"},
+		{"ток", "нос"}, {"ток", "сон"},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("phraseAnagrams(2 words) = %v, expected %v", result, expected)
+	}
+
+	// Три слова и ограничение числа результатов
+	result = index.phraseAnagrams("носкот", 3, 2)
+	if len(result) != 2 {
+		t.Errorf("phraseAnagrams(limit 2) returned %d phrases", len(result))
+	}
+	found := false
+	for _, words := range index.phraseAnagrams("носкот", 3, 0) {
+		if reflect.DeepEqual(words, []string{"кот", "он", "с"}) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("phraseAnagrams(3 words) expected phrase [кот он с]")
+	}
+
+	// Одинаковые слова в фразе не повторяются в разных порядках
+	index.add("ад")
+	index.add("да")
+	if result := index.phraseAnagrams("адда", 2, 0); !reflect.DeepEqual(result, [][]string{{"ад", "ад"}, {"ад", "да"}, {"да", "да"}}) {
+		t.Errorf("phraseAnagrams(адда) = %v", result)
+	}
+}
+
+// Тест нормализации слов перед вычислением сигнатуры
+func TestNormalization(t *testing.T) {
+	// й и ё в составном (NFC) и разложенном (NFD) виде
+	yodNFC, yodNFD := "йод", "и\u0306од"
+	yolkaNFD := "е\u0308лка"
+
+	tests := []struct {
+		name     string
+		words    []string
+		opts     normOptions
+		expected map[string][]string
+	}{
+		{
+			name:     "no normalization",
+			words:    []string{yodNFC, yodNFD, "ёлка", "лека"},
+			expected: map[string][]string{},
+		},
+		{
+			name:     "nfc joins composed and decomposed letters",
+			words:    []string{yodNFC, "дой", yodNFD},
+			opts:     normOptions{Form: "nfc"},
+			expected: map[string][]string{yodNFC: {"дой", yodNFC}},
+		},
+		{
+			name:     "nfd joins composed and decomposed letters",
+			words:    []string{yodNFD, yodNFC, "дой"},
+			opts:     normOptions{Form: "nfd"},
+			expected: map[string][]string{yodNFD: {"дой", yodNFD}},
+		},
+		{
+			name:     "yo folding keeps original spellings",
+			words:    []string{yolkaNFD, "Ёлка", "лека"},
+			opts:     normOptions{FoldYo: true},
+			expected: map[string][]string{yolkaNFD: {yolkaNFD, "лека"}},
+		},
+		{
+			name:     "non-letters are ignored",
+			words:    []string{"кто-то", "отток!", "ток", "---"},
+			opts:     normOptions{LettersOnly: true},
+			expected: map[string][]string{"кто-то": {"кто-то", "отток!"}},
+		},
+	}
+
+	for _, test := range tests {
+		result := findAnagramsWithOptions(test.words, test.opts)
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("%s: findAnagramsWithOptions() = %q, expected %q", test.name, result, test.expected)
+		}
+	}
+
+	// Слово в другом написании — повтор, а не своя анаграмма
+	nfc := newAnagramIndexWithOptions(normOptions{Form: "nfc"})
+	for _, word := range []string{yodNFC, yodNFD, "дой"} {
+		nfc.add(word)
+	}
+	if result := nfc.lookup(yodNFD); !reflect.DeepEqual(result, []string{"дой"}) {
+		t.Errorf("lookup(%q) = %q, expected [дой]", yodNFD, result)
+	}
+	if result := nfc.lookup("ДОЙ"); !reflect.DeepEqual(result, []string{yodNFC}) {
+		t.Errorf("lookup(ДОЙ) = %q, expected [%s]", result, yodNFC)
+	}
+
+	// Запросы нормализуются так же, как слова словаря
+	index := newAnagramIndexWithOptions(normOptions{FoldYo: true, LettersOnly: true})
+	for _, word := range []string{"ёлка", "колея", "секта"} {
+		index.add(word)
+	}
+	if result := index.lookup("ЛЕКА"); !reflect.DeepEqual(result, []string{"ёлка"}) {
+		t.Errorf("lookup(ЛЕКА) = %v, expected [ёлка]", result)
+	}
+	if result := index.subAnagrams("к-о-л-е-ё-я-а", 0); !reflect.DeepEqual(result, []string{"колея", "ёлка"}) {
+		t.Errorf("subAnagrams(к-о-л-е-ё-я-а) = %v, expected [колея ёлка]", result)
+	}
+
+	if _, err := parseNormForm("nfkc"); err == nil {
+		t.Errorf("parseNormForm(nfkc) expected error")
+	}
+}
+
+// Тест повторного использования индекса с другими параметрами нормализации
+func TestOpenIndexOptions(t *testing.T) {
+	dir := t.TempDir()
+	dictPath := filepath.Join(dir, "dict.txt")
+	indexPath := filepath.Join(dir, "dict.idx")
+	if err := os.WriteFile(dictPath, []byte("ёлка\nлека\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := openIndex(dictPath, indexPath, normOptions{}); err != nil {
+		t.Fatalf("openIndex() error: %v", err)
+	}
+
+	// Индекс перестраивается по словарю с новыми параметрами
+	folded := normOptions{FoldYo: true}
+	index, err := openIndex(dictPath, indexPath, folded)
+	if err != nil {
+		t.Fatalf("openIndex(fold-yo) error: %v", err)
+	}
+	if result := index.lookup("ёлка"); !reflect.DeepEqual(result, []string{"лека"}) {
+		t.Errorf("lookup(ёлка) = %v, expected [лека]", result)
+	}
+
+	// Без словаря индекс с другими параметрами не используется
+	if _, err := openIndex("", indexPath, normOptions{}); !errors.Is(err, errIndexOptions) {
+		t.Errorf("openIndex() error = %v, expected %v", err, errIndexOptions)
+	}
+	if index, err := openIndex("", indexPath, folded); err != nil || index.opts != folded {
+		t.Errorf("openIndex() from saved index = %v, %v", index, err)
+	}
+}
+
+// Тест перестроения индекса при изменении словаря
+func TestOpenIndexDictionaryChange(t *testing.T) {
+	dir := t.TempDir()
+	dictPath := filepath.Join(dir, "dict.txt")
+	indexPath := filepath.Join(dir, "dict.idx")
+	if err := os.WriteFile(dictPath, []byte("кот\nток\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openIndex(dictPath, indexPath, normOptions{}); err != nil {
+		t.Fatalf("openIndex() error: %v", err)
+	}
+
+	// Словарь той же длины с другими словами: индекс строится заново
+	if err := os.WriteFile(dictPath, []byte("сон\nнос\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	index, err := openIndex(dictPath, indexPath, normOptions{})
+	if err != nil {
+		t.Fatalf("openIndex() after dictionary change error: %v", err)
+	}
+	if result := index.lookup("нос"); !reflect.DeepEqual(result, []string{"сон"}) {
+		t.Errorf("lookup(нос) = %v, expected [сон]", result)
+	}
+	if result := index.lookup("кот"); result != nil {
+		t.Errorf("lookup(кот) = %v, expected none", result)
+	}
+
+	// Перестроенный индекс сохранён в файл
+	saved, err := openIndex("", indexPath, normOptions{})
+	if err != nil {
+		t.Fatalf("openIndex() from index file error: %v", err)
+	}
+	if result := saved.lookup("сон"); !reflect.DeepEqual(result, []string{"нос"}) {
+		t.Errorf("lookup(сон) from index file = %v, expected [нос]", result)
+	}
+}
+
+// Поиск анаграмм в большом словаре
+func BenchmarkLookup(b *testing.B) {
+	letters := []rune("абвгдежзийклмнопрстуфхцчшщъыьэюя")
+	random := rand.New(rand.NewSource(1))
+	index := newAnagramIndex()
+	for i := 0; i < 300000; i++ {
+		word := make([]rune, 4+random.Intn(8))
+		for j := range word {
+			word[j] = letters[random.Intn(len(letters))]
+		}
+		index.add(string(word))
+	}
+	index.add("листок")
+	index.add("столик")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		index.lookup("слиток")
+	}
+}
@@ -4,6 +4,10 @@ go 1.22.2
 
 require github.com/beevik/ntp v1.4.3
 
+require github.com/rivo/uniseg v0.4.7
+
+require golang.org/x/text v0.15.0
+
 require (
 	golang.org/x/net v0.25.0
 	golang.org/x/sys v0.20.0 // indirect